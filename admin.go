@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/nkinkade/disco-go/metrics"
+	"github.com/nkinkade/disco-go/snmp"
+)
+
+var adminPage = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head><title>DISCOv2: {{.Status.Target}}</title></head>
+<body>
+<h1>{{.Status.Target}}</h1>
+<p>Collecting for {{.Status.Hostname}}. Last collection: {{.Status.LastCollected}}.
+Last archive: {{if .Status.LastArchive}}{{.Status.LastArchive}} at {{.Status.LastArchived}}{{else}}none{{end}}.</p>
+{{if .Actions}}
+<form method="post" action="/admin/rediscover?redirect=1"><button>Rediscover interfaces</button></form>
+<form method="post" action="/admin/flush?redirect=1"><button>Flush archive</button></form>
+{{end}}
+<h2>Interfaces</h2>
+<table border="1">
+<tr><th>Scope</th><th>ifIndex</th><th>ifDescr</th><th>ifAlias</th></tr>
+{{range $scope, $iface := .Status.Interfaces}}<tr><td>{{$scope}}</td><td>{{$iface.IfIndex}}</td><td>{{$iface.IfDescr}}</td><td>{{$iface.IfAlias}}</td></tr>
+{{end}}</table>
+<h2>Series</h2>
+<table border="1">
+<tr><th>Metric</th><th>OID</th><th>Scope</th><th>Interface or labels</th><th>Value</th><th>Delta</th><th>Buffered</th></tr>
+{{range .Status.Series}}<tr><td>{{.Metric}}</td><td>{{.OID}}</td><td>{{.Scope}}</td><td>{{.Interface}}{{range $k, $v := .Labels}} {{$k}}={{$v}}{{end}}</td><td>{{if .Collected}}{{.Value}}{{end}}</td><td>{{if .Counter}}{{.Delta}}{{end}}</td><td>{{.Buffered}}</td></tr>
+{{end}}</table>
+<p>Samples buffered for removed series: {{.Status.Retired}}</p>
+<h2>Recent errors</h2>
+<ul>
+{{range .Status.Errors}}<li>{{.Time}}: {{.Message}}</li>
+{{else}}<li>None</li>
+{{end}}</ul>
+<h2>Configuration</h2>
+<pre>{{.Status.Config}}</pre>
+</body>
+</html>
+`))
+
+// serveAdmin adds the admin page, at /admin, and its JSON equivalent, at
+// /admin/status, to the metrics server. If actions is true it also adds POST
+// endpoints to rediscover the interfaces of the switch, at /admin/rediscover,
+// and to archive the buffered samples at once, at /admin/flush.
+func serveAdmin(srv *http.Server, m *metrics.Metrics, client snmp.SNMP, actions bool) error {
+	mux, err := serveMux(srv)
+	if err != nil {
+		return err
+	}
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := adminPage.Execute(w, struct {
+			Status  metrics.Status
+			Actions bool
+		}{m.Status(), actions})
+		if err != nil {
+			slog.Error("Failed to render the admin page", "error", err)
+		}
+	})
+	mux.HandleFunc("/admin/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Status())
+	})
+	if !actions {
+		return nil
+	}
+	mux.Handle("/admin/rediscover", adminAction(func(r *http.Request) error {
+		slog.Info("Rediscovering the interfaces", "target", *fTarget, "remote", r.RemoteAddr)
+		return m.Rediscover(r.Context(), client)
+	}))
+	mux.Handle("/admin/flush", adminAction(func(r *http.Request) error {
+		slog.Info("Flushing the archive", "target", *fTarget, "remote", r.RemoteAddr)
+		m.Flush(*fWriteInterval)
+		return nil
+	}))
+	return nil
+}
+
+// adminAction returns a handler that runs action for POST requests, replying
+// with its result as JSON, or redirecting back to the admin page if the
+// request has a redirect parameter, as from the page's forms.
+func adminAction(action func(r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result := struct {
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+		}{Status: "ok"}
+		status := http.StatusOK
+		if err := action(r); err != nil {
+			result.Status, result.Error = "fail", err.Error()
+			status = http.StatusInternalServerError
+		}
+		if r.URL.Query().Get("redirect") != "" && status == http.StatusOK {
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/metrics"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_ServeAdmin(t *testing.T) {
+	// The collectors are registered with a registry of their own, which is
+	// replaced afterwards, so that Test_Run can gather from the default one.
+	defer func(r prometheus.Registerer) { prometheus.DefaultRegisterer = r }(prometheus.DefaultRegisterer)
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	defer os.RemoveAll("2020")
+
+	replay, err := snmp.LoadReplay("metrics/testdata/juniper-qfx5100.snmprec")
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+	c := config.Config{Metrics: []config.Metric{{
+		Name:            "ifHCInOctets",
+		OidStub:         ".1.3.6.1.2.1.31.1.1.1.6",
+		MlabUplinkName:  "switch.octets.uplink.rx",
+		MlabMachineName: "switch.octets.local.rx",
+	}}}
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	m := metrics.New(context.Background(), replay, c, "s1-abc0t.measurement-lab.org", "mlab2-abc0t.mlab-sandbox.measurement-lab.org", f)
+
+	request := func(srv *http.Server, method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	srv := &http.Server{Handler: http.NewServeMux()}
+	if err := serveAdmin(srv, m, replay, false); err != nil {
+		t.Fatalf("serveAdmin() error = %v", err)
+	}
+	rec := request(srv, "GET", "/admin")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "xe-0/0/12") {
+		t.Errorf("GET /admin = %v, expected the page with the machine interface:\n%v", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "<form") {
+		t.Errorf("Expected no actions on the page when they are disabled")
+	}
+	rec = request(srv, "GET", "/admin/status")
+	status := metrics.Status{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Could not decode GET /admin/status: %v", err)
+	}
+	if status.Interfaces["uplink"].IfDescr != "xe-0/0/45" || len(status.Series) != 2 {
+		t.Errorf("GET /admin/status = %+v", status)
+	}
+	if rec = request(srv, "POST", "/admin/flush"); rec.Code != http.StatusNotFound {
+		t.Errorf("POST /admin/flush with actions disabled = %v, want %v", rec.Code, http.StatusNotFound)
+	}
+
+	srv = &http.Server{Handler: http.NewServeMux()}
+	if err := serveAdmin(srv, m, replay, true); err != nil {
+		t.Fatalf("serveAdmin() error = %v", err)
+	}
+	if rec = request(srv, "GET", "/admin/rediscover"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/rediscover = %v, want %v", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec = request(srv, "POST", "/admin/rediscover"); rec.Code != http.StatusOK {
+		t.Errorf("POST /admin/rediscover = %v: %v", rec.Code, rec.Body)
+	}
+	f.Advance(90 * time.Second)
+	if rec = request(srv, "POST", "/admin/flush?redirect=1"); rec.Code != http.StatusSeeOther {
+		t.Errorf("POST /admin/flush?redirect=1 = %v, want %v", rec.Code, http.StatusSeeOther)
+	}
+	if m.Status().LastArchive == "" {
+		t.Errorf("Expected POST /admin/flush to write an archive")
+	}
+}
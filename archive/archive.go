@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"time"
@@ -12,18 +12,36 @@ import (
 	"github.com/m-lab/go/rtx"
 )
 
-// Sample represents the basic structure for metric samples.
+// Sample represents the basic structure for metric samples. For counters the
+// value is the increase since the previous sample, while for gauges it is the
+// absolute value at the time of the sample.
 type Sample struct {
-	Timestamp int64  `json:"timestamp"`
-	Value     uint64 `json:"value"`
+	Timestamp int64 `json:"timestamp"`
+	Value     int64 `json:"value"`
 }
 
-// Model represents the structure of metric for DISCO.
+// Model represents the structure of metric for DISCO. Labels distinguish the
+// rows of a table metric, and are omitted for all other metrics.
 type Model struct {
-	Experiment string   `json:"experiment"`
-	Hostname   string   `json:"hostname"`
-	Metric     string   `json:"metric"`
-	Samples    []Sample `json:"sample"`
+	Experiment string            `json:"experiment"`
+	Hostname   string            `json:"hostname"`
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Samples    []Sample          `json:"sample"`
+}
+
+// Event represents a notification received from a switch, such as a link going
+// down. Interface is "machine" or "uplink" if the notification was about one
+// of those interfaces, and is omitted otherwise.
+type Event struct {
+	Experiment string `json:"experiment"`
+	Hostname   string `json:"hostname"`
+	Event      string `json:"event"`
+	Timestamp  int64  `json:"timestamp"`
+	Source     string `json:"source"`
+	IfIndex    string `json:"ifIndex,omitempty"`
+	IfDescr    string `json:"ifDescr,omitempty"`
+	Interface  string `json:"interface,omitempty"`
 }
 
 // GetJSON accepts a Model object and returns marshalled JSON.
@@ -33,17 +51,51 @@ func GetJSON(m Model) ([]byte, error) {
 	return data, err
 }
 
-// GetPath returns a relative filesystem path where an archive should be written.
-func GetPath(now time.Time, hostname string, interval uint64) string {
+// GetEventJSON accepts an Event object and returns marshalled JSON.
+func GetEventJSON(e Event) ([]byte, error) {
+	data, err := json.MarshalIndent(e, "", "    ")
+	rtx.Must(err, "ERROR: failed to marshal archive.Event to JSON. This should never happen")
+	return data, err
+}
+
+// GetPath returns a relative filesystem path where the archive of the interval
+// of interval seconds that starts at start should be written.
+func GetPath(start time.Time, hostname string, interval uint64) string {
+	return getPath(start, start.Add(time.Duration(interval)*time.Second), hostname, "switch")
+}
+
+// GetRangePath returns a relative filesystem path where an archive of the part
+// of an interval from start to end should be written, as when the samples of
+// an interval are flushed before it ends.
+func GetRangePath(start, end time.Time, hostname string) string {
+	return getPath(start, end, hostname, "switch")
+}
+
+// GetEventsPath returns a relative filesystem path where an archive of events
+// should be written. Events are archived as their own datatype, alongside the
+// archives of metrics.
+func GetEventsPath(start time.Time, hostname string, interval uint64) string {
+	return getPath(start, start.Add(time.Duration(interval)*time.Second), hostname, "switch-events")
+}
+
+// GetEventsRangePath returns a relative filesystem path where an archive of
+// the events of the part of an interval from start to end should be written,
+// as when events are flushed before the interval ends.
+func GetEventsRangePath(start, end time.Time, hostname string) string {
+	return getPath(start, end, hostname, "switch-events")
+}
+
+// getPath returns the path of an archive of datatype. The archive is placed in
+// the directory of the day the interval starts on, so that an interval ending
+// at midnight belongs to the day that is ending.
+func getPath(start, end time.Time, hostname string, datatype string) string {
 	// The directory path where the archive should be written.
-	dirs := fmt.Sprintf("%v/%v", now.Format("2006/01/02"), hostname)
-
-	// Calculate the start time, which will be Now() - interval, and then format
-	// the archive file name based on the calculated values.
-	startTime := now.Add(time.Duration(interval) * -time.Second)
-	startTimeStr := startTime.Format("2006-01-02T15:04:05")
-	endTimeStr := now.Format("2006-01-02T15:04:05")
-	archiveName := fmt.Sprintf("%v-to-%v-switch.json", startTimeStr, endTimeStr)
+	dirs := fmt.Sprintf("%v/%v", start.Format("2006/01/02"), hostname)
+
+	// Format the archive file name based on the bounds of the interval.
+	startTimeStr := start.Format("2006-01-02T15:04:05")
+	endTimeStr := end.Format("2006-01-02T15:04:05")
+	archiveName := fmt.Sprintf("%v-to-%v-%v.json", startTimeStr, endTimeStr, datatype)
 	archivePath := fmt.Sprintf("%v/%v", dirs, archiveName)
 
 	return archivePath
@@ -54,15 +106,26 @@ func Write(archivePath string, data []byte) error {
 	dirPath := path.Dir(archivePath)
 	err := os.MkdirAll(dirPath, 0755)
 	if err != nil {
-		log.Printf("ERROR: failed to create archive directory path '%v': %v", dirPath, err)
+		slog.Error("Failed to create the archive directory", "path", dirPath, "error", err)
 		return err
 	}
 
 	err = ioutil.WriteFile(archivePath, data, 0644)
 	if err != nil {
-		log.Printf("ERROR: failed to write archive file '%v': %v", archivePath, err)
+		slog.Error("Failed to write the archive", "path", archivePath, "error", err)
 		return err
 	}
 
 	return nil
 }
+
+// CheckWritable returns an error if archives cannot be written under dir, by
+// creating and removing a temporary file there.
+func CheckWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".writable-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
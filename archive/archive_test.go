@@ -62,23 +62,29 @@ func Test_GetPath(t *testing.T) {
 		expect   string
 	}{
 		{
-			t:        time.Date(2010, 04, 18, 20, 34, 50, 0, time.UTC),
+			t:        time.Date(2010, 04, 18, 20, 33, 50, 0, time.UTC),
 			interval: 60,
 			hostname: "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
 			expect:   "2010/04/18/mlab2-abc0t.mlab-sandbox.measurement-lab.org/2010-04-18T20:33:50-to-2010-04-18T20:34:50-switch.json",
 		},
 		{
-			t:        time.Date(1972, 07, 03, 11, 14, 10, 0, time.UTC),
+			t:        time.Date(1972, 07, 03, 11, 04, 10, 0, time.UTC),
 			interval: 600,
 			hostname: "mlab4-xyz03.mlab-staging.measurement-lab.org",
 			expect:   "1972/07/03/mlab4-xyz03.mlab-staging.measurement-lab.org/1972-07-03T11:04:10-to-1972-07-03T11:14:10-switch.json",
 		},
 		{
-			t:        time.Date(2020, 06, 11, 18, 18, 30, 0, time.UTC),
+			t:        time.Date(2020, 06, 11, 18, 13, 30, 0, time.UTC),
 			interval: 300,
 			hostname: "mlab1-qrs0t.mlab-sandbox.measurement-lab.org",
 			expect:   "2020/06/11/mlab1-qrs0t.mlab-sandbox.measurement-lab.org/2020-06-11T18:13:30-to-2020-06-11T18:18:30-switch.json",
 		},
+		{
+			t:        time.Date(2020, 06, 11, 23, 55, 0, 0, time.UTC),
+			interval: 300,
+			hostname: "mlab1-qrs0t.mlab-sandbox.measurement-lab.org",
+			expect:   "2020/06/11/mlab1-qrs0t.mlab-sandbox.measurement-lab.org/2020-06-11T23:55:00-to-2020-06-12T00:00:00-switch.json",
+		},
 	}
 
 	for _, tt := range tests {
@@ -89,6 +95,62 @@ func Test_GetPath(t *testing.T) {
 	}
 }
 
+func Test_GetEventsPath(t *testing.T) {
+	start := time.Date(2020, 06, 11, 18, 13, 30, 0, time.UTC)
+	expect := "2020/06/11/mlab1-qrs0t.mlab-sandbox.measurement-lab.org/2020-06-11T18:13:30-to-2020-06-11T18:18:30-switch-events.json"
+	archivePath := GetEventsPath(start, "mlab1-qrs0t.mlab-sandbox.measurement-lab.org", 300)
+	if archivePath != expect {
+		t.Errorf("Expected archive path '%v', but got: %v", expect, archivePath)
+	}
+}
+
+func Test_GetRangePath(t *testing.T) {
+	start := time.Date(2020, 06, 11, 18, 15, 0, 0, time.UTC)
+	end := time.Date(2020, 06, 11, 18, 17, 30, 0, time.UTC)
+	expect := "2020/06/11/mlab1-qrs0t.mlab-sandbox.measurement-lab.org/2020-06-11T18:15:00-to-2020-06-11T18:17:30-switch.json"
+	archivePath := GetRangePath(start, end, "mlab1-qrs0t.mlab-sandbox.measurement-lab.org")
+	if archivePath != expect {
+		t.Errorf("Expected archive path '%v', but got: %v", expect, archivePath)
+	}
+}
+
+func Test_GetEventsRangePath(t *testing.T) {
+	start := time.Date(2020, 06, 11, 18, 15, 0, 0, time.UTC)
+	end := time.Date(2020, 06, 11, 18, 17, 30, 0, time.UTC)
+	expect := "2020/06/11/mlab1-qrs0t.mlab-sandbox.measurement-lab.org/2020-06-11T18:15:00-to-2020-06-11T18:17:30-switch-events.json"
+	archivePath := GetEventsRangePath(start, end, "mlab1-qrs0t.mlab-sandbox.measurement-lab.org")
+	if archivePath != expect {
+		t.Errorf("Expected archive path '%v', but got: %v", expect, archivePath)
+	}
+}
+
+func Test_GetEventJSON(t *testing.T) {
+	e := Event{
+		Experiment: "s1-abc0t.measurement-lab.org",
+		Hostname:   "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+		Event:      "linkDown",
+		Timestamp:  1591845348,
+		Source:     "192.168.0.1",
+		IfIndex:    "524",
+		IfDescr:    "xe-0/0/12",
+		Interface:  "machine",
+	}
+	expect := `{
+    "experiment": "s1-abc0t.measurement-lab.org",
+    "hostname": "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+    "event": "linkDown",
+    "timestamp": 1591845348,
+    "source": "192.168.0.1",
+    "ifIndex": "524",
+    "ifDescr": "xe-0/0/12",
+    "interface": "machine"
+}`
+	jsonData, err := GetEventJSON(e)
+	if err != nil || string(jsonData) != expect {
+		t.Errorf("GetEventJSON() = %v, %v, want %v", string(jsonData), err, expect)
+	}
+}
+
 func Test_WriteBadPath(t *testing.T) {
 	dir, err := ioutil.TempDir("", "TestWrite")
 	rtx.Must(err, "Could not create tempdir")
@@ -143,3 +205,21 @@ func Test_Write(t *testing.T) {
 	}
 
 }
+
+func Test_CheckWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCheckWritable")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	if err := CheckWritable(dir); err != nil {
+		t.Errorf("CheckWritable() error = %v", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	rtx.Must(err, "Could not read tempdir")
+	if len(files) != 0 {
+		t.Errorf("Expected CheckWritable() to leave no files, got %v", len(files))
+	}
+	if err := CheckWritable(dir + "/missing"); err == nil {
+		t.Errorf("Expected CheckWritable() of a missing directory to fail")
+	}
+}
@@ -0,0 +1,157 @@
+// Package clock abstracts the passing of time, so that code that runs on a
+// schedule can be tested deterministically with a Fake clock.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event in the future, as with time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Real is the system clock.
+type Real struct{}
+
+// Now returns the current time.
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer returns a timer that fires after d.
+func (Real) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// Fake is a clock that only moves when it is advanced. It is safe for
+// concurrent use.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+// NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// NewTimer returns a timer that fires once the clock has been advanced by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t := &fakeTimer{
+		f:        f,
+		deadline: f.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- f.now
+		return t
+	}
+	f.timers = append(f.timers, t)
+	f.notify()
+	return t
+}
+
+// Set moves the clock to now, firing any timers that are due in order of
+// their deadlines. The clock may be moved backwards, as the system clock can
+// be, but timers only fire once their deadline has passed.
+func (f *Fake) Set(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = now
+	sort.SliceStable(f.timers, func(i, j int) bool { return f.timers[i].deadline.Before(f.timers[j].deadline) })
+	pending := []*fakeTimer{}
+	for _, t := range f.timers {
+		if t.deadline.After(now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- now
+	}
+	f.timers = pending
+	f.notify()
+}
+
+// Advance moves the clock forward by d, firing any timers that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// BlockUntil waits until at least n timers are pending, which is how a test
+// knows that the code it runs is waiting for the clock to be advanced.
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mutex.Lock()
+		pending := len(f.timers)
+		changed := f.changed
+		f.mutex.Unlock()
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notify wakes anything waiting in BlockUntil. f.mutex must be held.
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeTimer struct {
+	f        *Fake
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mutex.Lock()
+	defer t.f.mutex.Unlock()
+	for i, pending := range t.f.timers {
+		if pending == t {
+			t.f.timers = append(t.f.timers[:i], t.f.timers[i+1:]...)
+			t.f.notify()
+			return true
+		}
+	}
+	return false
+}
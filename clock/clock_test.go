@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Fake(t *testing.T) {
+	start := time.Date(2020, 6, 11, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	early := f.NewTimer(time.Second)
+	late := f.NewTimer(time.Minute)
+	stopped := f.NewTimer(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("Expected Stop() to return true only for a pending timer")
+	}
+	f.BlockUntil(2)
+
+	f.Advance(30 * time.Second)
+	select {
+	case now := <-early.C():
+		if !now.Equal(start.Add(30 * time.Second)) {
+			t.Errorf("Timer fired at %v", now)
+		}
+	default:
+		t.Errorf("Expected the timer to fire")
+	}
+	select {
+	case <-late.C():
+		t.Errorf("Expected the later timer not to fire yet")
+	case <-stopped.C():
+		t.Errorf("Expected the stopped timer not to fire")
+	default:
+	}
+
+	f.Set(start)
+	select {
+	case <-late.C():
+		t.Errorf("Expected the timer not to fire when the clock is set back")
+	default:
+	}
+
+	if now := <-f.NewTimer(0).C(); !now.Equal(start) {
+		t.Errorf("Timer with no duration fired at %v", now)
+	}
+}
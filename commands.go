@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/metrics"
+	"github.com/nkinkade/disco-go/snmp"
+)
+
+// machineHostname returns --hostname, or the hostname of the system if it
+// is unset.
+func machineHostname() (string, error) {
+	if *fHostname != "" {
+		return *fHostname, nil
+	}
+	return osHostname()
+}
+
+// discover connects to the switch and writes to w which of its interfaces are
+// the machine and uplink interfaces, and why, listing every interface that has
+// an ifAlias. It returns an error if either is missing, as run would then not
+// collect their metrics.
+func discover(ctx context.Context, w io.Writer) error {
+	host, err := machineHostname()
+	if err != nil {
+		return fmt.Errorf("failed to determine the hostname of the system: %v", err)
+	}
+	if len(host) < 5 {
+		return fmt.Errorf("the hostname %q is too short to name a machine", host)
+	}
+	machine := host[:5]
+
+	managed, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer managed.Close()
+
+	d, err := metrics.Discover(ctx, managed, machine)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "IFINDEX\tIFALIAS\tSCOPE\tSELECTED\tREASON\n")
+	unnamed := 0
+	for _, c := range d.Candidates {
+		if c.IfAlias == "" {
+			unnamed++
+			continue
+		}
+		scope := c.Scope
+		if scope == "" {
+			scope = "-"
+		}
+		fmt.Fprintf(tw, "%v\t%q\t%v\t%v\t%v\n", c.IfIndex, c.IfAlias, scope, c.Selected, c.Reason)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "(%v interfaces without an ifAlias not shown)\n\n", unnamed)
+
+	missing := []string{}
+	for _, scope := range []string{"machine", "uplink"} {
+		iface, ok := d.Interfaces[scope]
+		if !ok {
+			fmt.Fprintf(w, "%v: not found\n", scope)
+			missing = append(missing, scope)
+			continue
+		}
+		fmt.Fprintf(w, "%v: ifIndex %v, ifDescr %q, ifAlias %q\n", scope, iface.IfIndex, iface.IfDescr, iface.IfAlias)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("could not find the %v interface of %v on %v", strings.Join(missing, " or "), machine, *fTarget)
+	}
+	return nil
+}
+
+// resolveOIDs returns the numeric OIDs of names, which may be numeric OIDs or
+// symbolic names defined in the standard MIBs or the --mib-file MIBs.
+func resolveOIDs(names []string) ([]string, error) {
+	mibs, err := config.NewMIBs(fMIBFiles...)
+	if err != nil {
+		return nil, err
+	}
+	oids := []string{}
+	for _, name := range names {
+		oid, err := mibs.Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+// get reads the variables names from the switch with a GET, as run does, and
+// writes them to w, one per line.
+func get(ctx context.Context, w io.Writer, names []string) error {
+	oids, err := resolveOIDs(names)
+	if err != nil {
+		return err
+	}
+	managed, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer managed.Close()
+
+	packet, err := snmp.NewBatched(managed, *fMaxOids).Get(ctx, oids)
+	if err != nil {
+		return fmt.Errorf("failed to GET %v: %v", strings.Join(names, " "), err)
+	}
+	for _, pdu := range packet.Variables {
+		fmt.Fprintln(w, snmp.Format(pdu))
+	}
+	return nil
+}
+
+// walk walks each of the subtrees roots on the switch, as run walks tables,
+// and writes the variables found to w, one per line.
+func walk(ctx context.Context, w io.Writer, roots []string) error {
+	oids, err := resolveOIDs(roots)
+	if err != nil {
+		return err
+	}
+	managed, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer managed.Close()
+
+	for i, oid := range oids {
+		pdus, err := managed.BulkWalkAll(ctx, oid)
+		if err != nil {
+			return fmt.Errorf("failed to walk %v: %v", roots[i], err)
+		}
+		for _, pdu := range pdus {
+			fmt.Fprintln(w, snmp.Format(pdu))
+		}
+	}
+	return nil
+}
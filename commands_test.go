@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nkinkade/disco-go/internal/snmpsim"
+	"github.com/soniah/gosnmp"
+)
+
+// startAgent starts a simulated switch with a machine interface, mlab2, and
+// points the SNMP flags at it for the rest of the test.
+func startAgent(t *testing.T) *snmpsim.Agent {
+	agent, err := snmpsim.New("snmp-community")
+	if err != nil {
+		t.Fatalf("Failed to start the simulated switch: %v", err)
+	}
+	agent.Set(".1.3.6.1.2.1.1.5.0", gosnmp.OctetString, "s1-abc0t")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.18.524", gosnmp.OctetString, "mlab2")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.18.525", gosnmp.OctetString, "")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.18.526", gosnmp.OctetString, "mlab3")
+	agent.Set(".1.3.6.1.2.1.2.2.1.2.524", gosnmp.OctetString, "xe-0/0/12")
+	agent.Set(".1.3.6.1.2.1.2.2.1.2.526", gosnmp.OctetString, "xe-0/0/13")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.6.524", gosnmp.Counter64, uint64(1000))
+
+	setFlags(t, "snmp-community", map[string]string{
+		"target":                  "127.0.0.1",
+		"hostname":                "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+		"snmp-port":               fmt.Sprint(agent.Port()),
+		"snmp-timeout":            "500ms",
+		"snmpv3-credentials-file": "",
+		"community-file":          "",
+		"snmp-source-address":     "",
+	})
+	return agent
+}
+
+func Test_Discover(t *testing.T) {
+	agent := startAgent(t)
+	defer agent.Close()
+
+	out := &bytes.Buffer{}
+	err := discover(context.Background(), out)
+	if err == nil || !strings.Contains(err.Error(), "could not find the uplink interface of mlab2") {
+		t.Errorf("Expected an error for the missing uplink, got %v", err)
+	}
+	for _, want := range []string{
+		`524      "mlab2"  machine  true      ifAlias is the machine name "mlab2"`,
+		`526      "mlab3"  -        false     ifAlias is neither`,
+		"(1 interfaces without an ifAlias not shown)",
+		`machine: ifIndex 524, ifDescr "xe-0/0/12", ifAlias "mlab2"`,
+		"uplink: not found",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%v", want, out)
+		}
+	}
+}
+
+func Test_GetAndWalk(t *testing.T) {
+	agent := startAgent(t)
+	defer agent.Close()
+
+	out := &bytes.Buffer{}
+	err := get(context.Background(), out, []string{"SNMPv2-MIB::sysName.0", ".1.3.6.1.2.1.31.1.1.1.6.524"})
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	want := ".1.3.6.1.2.1.1.5.0 = OctetString: \"s1-abc0t\"\n.1.3.6.1.2.1.31.1.1.1.6.524 = Counter64: 1000\n"
+	if out.String() != want {
+		t.Errorf("get() wrote %q, want %q", out, want)
+	}
+
+	out.Reset()
+	err = walk(context.Background(), out, []string{"ifDescr"})
+	if err != nil {
+		t.Fatalf("walk() error = %v", err)
+	}
+	want = ".1.3.6.1.2.1.2.2.1.2.524 = OctetString: \"xe-0/0/12\"\n.1.3.6.1.2.1.2.2.1.2.526 = OctetString: \"xe-0/0/13\"\n"
+	if out.String() != want {
+		t.Errorf("walk() wrote %q, want %q", out, want)
+	}
+
+	if err := get(context.Background(), out, []string{"noSuchName"}); err == nil {
+		t.Errorf("Expected an error for an unknown name")
+	}
+}
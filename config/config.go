@@ -1,41 +1,211 @@
 package config
 
 import (
+	"bytes"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"sort"
+	"time"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// The types of metric that can be configured. A Counter is a monotonically
+// increasing value which is archived as the increase since the previous scrape.
+// All other types are archived as the absolute value at the time of the scrape.
+const (
+	// Counter is a monotonically increasing value, e.g. ifHCInOctets.
+	Counter = "counter"
+	// Gauge is a value that can go up or down, e.g. ifHighSpeed or a temperature.
+	Gauge = "gauge"
+	// Enum is an integer representing a state, e.g. ifOperStatus.
+	Enum = "enum"
+	// TimeTicks is a time in hundredths of a second, e.g. sysUpTime.
+	TimeTicks = "timeticks"
+)
+
+// The modes in which a metric can be collected.
+const (
+	// Interface metrics are collected for the machine's interface and the
+	// switch's uplink, with OidStub being joined to the ifIndex of each.
+	Interface = "interface"
+	// Scalar metrics are collected from the single OID in OidStub.
+	Scalar = "scalar"
+	// Table metrics are collected by walking every row of the table column in
+	// OidStub, with labels derived from the index of each row.
+	Table = "table"
 )
 
 // Config represents a collection of Metrics.
 type Config struct {
 	Metrics []Metric
+	// lines holds the line numbers of each metric in the YAML file, if the
+	// Config was loaded from one, for use in validation errors.
+	lines []lineNumbers
 }
 
 // Metric represents all the information needed for an SNMP metric.
 type Metric struct {
-	Name            string `yaml:"name"`
-	Description     string `yaml:"description"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Type        string `yaml:"type"`
+	Mode        string `yaml:"mode"`
+	// OidStub may be a numeric OID or a symbolic name resolved against the
+	// loaded MIBs, e.g. "IF-MIB::ifHCInOctets". Once the config is loaded it is
+	// always numeric.
 	OidStub         string `yaml:"oidStub"`
-	MlabUplinkName  string `yaml:"mlabUplinkName"`
-	MlabMachineName string `yaml:"mlabMachineName"`
+	MlabUplinkName  string `yaml:"mlabUplinkName,omitempty"`
+	MlabMachineName string `yaml:"mlabMachineName,omitempty"`
+	// MlabName is the archive metric name for scalar and table metrics.
+	MlabName string `yaml:"mlabName,omitempty"`
+	// IndexLabel is the name of the label holding the row index of a table
+	// metric. It defaults to "index".
+	IndexLabel string `yaml:"indexLabel,omitempty"`
+	// LabelOids maps additional label names for a table metric to the OID stub
+	// of a column whose value at the same index is used as the label value,
+	// e.g. "ifDescr: .1.3.6.1.2.1.2.2.1.2".
+	LabelOids map[string]string `yaml:"labelOids,omitempty"`
+	// Interval is how often the metric is collected, e.g. "60s", which must be
+	// a multiple of the poll interval. Unset, it is collected on every poll.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// OidName and LabelOidNames hold the symbolic names, if any, that
+	// OidStub and LabelOids were resolved from.
+	OidName       string            `yaml:"-"`
+	LabelOidNames map[string]string `yaml:"-"`
 }
 
-// New returns a new Config struct.
-func New(yamlFile string) (Config, error) {
+// IsCounter reports whether the metric is a counter, in which case the
+// increase between scrapes is recorded rather than the absolute value.
+func (m Metric) IsCounter() bool {
+	return m.Type == "" || m.Type == Counter
+}
+
+// New returns a new Config struct. Symbolic OID names are resolved against the
+// standard MIBs bundled with disco plus those defined in mibFiles, and the
+// config is then validated. If there are any problems with the config then the
+// returned error is a ValidationErrors listing all of them.
+func New(yamlFile string, mibFiles ...string) (Config, error) {
 	var c Config
 
-	yamlData, err := ioutil.ReadFile(yamlFile)
+	mibs, err := NewMIBs(mibFiles...)
 	if err != nil {
-		log.Printf("ERROR: failed to read YAML metrics config file '%v': %v", yamlFile, err)
+		slog.Error("Failed to load MIB files", "files", mibFiles, "error", err)
 		return c, err
 	}
 
-	err = yaml.UnmarshalStrict(yamlData, &c.Metrics)
+	yamlData, err := ioutil.ReadFile(yamlFile)
 	if err != nil {
-		log.Printf("ERROR: failed to unmarshal YAML metrics config: %v", err)
+		slog.Error("Failed to read the metrics config", "file", yamlFile, "error", err)
 		return c, err
 	}
 
-	return c, err
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlData))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&c.Metrics)
+	if err != nil && err != io.EOF {
+		slog.Error("Failed to unmarshal the metrics config", "file", yamlFile, "error", err)
+		return c, err
+	}
+	c.lines = metricLines(yamlData)
+
+	errs := ValidationErrors{}
+	for i := range c.Metrics {
+		c.Metrics[i].setDefaults()
+		errs = append(errs, c.resolve(i, mibs)...)
+	}
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		slog.Error("Found problems in the metrics config", "file", yamlFile, "problems", len(errs))
+		return c, errs
+	}
+
+	return c, nil
+}
+
+// setDefaults sets the default values of any optional fields that are unset.
+func (m *Metric) setDefaults() {
+	if m.Type == "" {
+		// Metrics were counters before the type field existed.
+		m.Type = Counter
+	}
+	if m.Mode == "" {
+		m.Mode = Interface
+	}
+	if m.Mode == Table && m.IndexLabel == "" {
+		m.IndexLabel = "index"
+	}
+}
+
+// resolve replaces any symbolic OID names in the i'th metric with numeric
+// OIDs, and adds the leading dot to numeric OIDs without one, returning an
+// error for each name that cannot be resolved.
+func (c *Config) resolve(i int, mibs *MIBs) ValidationErrors {
+	errs := ValidationErrors{}
+	m := &c.Metrics[i]
+
+	// Malformed OIDs are reported by Validate.
+	if isOID(m.OidStub) {
+		oid, err := mibs.Resolve(m.OidStub)
+		if err != nil {
+			errs = append(errs, c.validationError(i, "oidStub", "%v", err))
+		} else if oid != m.OidStub {
+			if !IsNumericOID(m.OidStub) {
+				m.OidName = m.OidStub
+			}
+			m.OidStub = oid
+		}
+	}
+
+	for _, label := range sortedKeys(m.LabelOids) {
+		name := m.LabelOids[label]
+		if !isOID(name) {
+			continue
+		}
+		oid, err := mibs.Resolve(name)
+		if err != nil {
+			errs = append(errs, c.validationError(i, "labelOids", "label '%v': %v", label, err))
+			continue
+		}
+		if oid != name && !IsNumericOID(name) {
+			if m.LabelOidNames == nil {
+				m.LabelOidNames = make(map[string]string)
+			}
+			m.LabelOidNames[label] = name
+		}
+		m.LabelOids[label] = oid
+	}
+
+	return errs
+}
+
+// printedMetric is a Metric as written by Print, with any symbolic names that
+// OIDs were resolved from shown alongside them.
+type printedMetric struct {
+	Metric        `yaml:",inline"`
+	OidName       string            `yaml:"oidName,omitempty"`
+	LabelOidNames map[string]string `yaml:"labelOidNames,omitempty"`
+}
+
+// Print writes the loaded configuration to w as YAML.
+func (c Config) Print(w io.Writer) error {
+	printed := []printedMetric{}
+	for _, m := range c.Metrics {
+		printed = append(printed, printedMetric{
+			Metric:        m,
+			OidName:       m.OidName,
+			LabelOidNames: m.LabelOidNames,
+		})
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(printed)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
 }
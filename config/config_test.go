@@ -1,10 +1,13 @@
 package config
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/m-lab/go/rtx"
 )
@@ -20,11 +23,72 @@ var goodYaml = `
 var goodYamlStruct = Metric{
 	Name:            "ifHCOutUcastPkts",
 	Description:     "Test",
+	Type:            "counter",
+	Mode:            "interface",
 	OidStub:         ".1.3.6.1.2.1.31.1.1.1.11",
 	MlabUplinkName:  "switch.unicast.uplink.tx",
 	MlabMachineName: "switch.unicast.local.tx",
 }
 
+var gaugeYaml = `
+- name: ifOperStatus
+  description: Test
+  type: enum
+  oidStub: .1.3.6.1.2.1.2.2.1.8
+  mlabUplinkName: switch.status.uplink
+  mlabMachineName: switch.status.local
+`
+
+var unknownTypeYaml = `
+- name: ifOperStatus
+  description: Test
+  type: histogram
+  oidStub: .1.3.6.1.2.1.2.2.1.8
+  mlabUplinkName: switch.status.uplink
+  mlabMachineName: switch.status.local
+`
+
+var tableYaml = `
+- name: entPhySensorValue
+  description: Test
+  type: gauge
+  mode: table
+  oidStub: .1.3.6.1.2.1.99.1.1.1.4
+  mlabName: switch.sensor.value
+  labelOids:
+    entPhysicalName: .1.3.6.1.2.1.47.1.1.1.1.7
+`
+
+var symbolicYaml = `
+- name: entPhySensorValue
+  description: Test
+  type: gauge
+  mode: table
+  oidStub: ENTITY-SENSOR-MIB::entPhySensorValue
+  mlabName: switch.sensor.value
+  labelOids:
+    entPhysicalName: ENTITY-MIB::entPhysicalName
+`
+
+var unknownSymbolYaml = `
+- name: ifHCInOctets
+  description: Test
+  oidStub: IF-MIB::ifHCInOctetz
+  mlabUplinkName: switch.octets.uplink.rx
+  mlabMachineName: switch.octets.local.rx
+`
+
+var undottedYaml = `
+- name: entPhySensorValue
+  description: Test
+  type: gauge
+  mode: table
+  oidStub: 1.3.6.1.2.1.99.1.1.1.4
+  mlabName: switch.sensor.value
+  labelOids:
+    entPhysicalName: 1.3.6.1.2.1.47.1.1.1.1.7
+`
+
 var badYaml = `
 - badName: ifHCOutUcastPkts
   description: Egress unicast packets.
@@ -72,3 +136,158 @@ func TestGoodYamlFile(t *testing.T) {
 		t.Errorf("Expected Metric '%v' but got: %v", goodYamlStruct, m)
 	}
 }
+
+var intervalYaml = `
+- name: ifInErrors
+  description: Test
+  oidStub: .1.3.6.1.2.1.2.2.1.14
+  mlabUplinkName: switch.errors.uplink.rx
+  mlabMachineName: switch.errors.local.rx
+  interval: 60s
+- name: ifOutErrors
+  description: Test
+  oidStub: .1.3.6.1.2.1.2.2.1.20
+  mlabUplinkName: switch.errors.uplink.tx
+  mlabMachineName: switch.errors.local.tx
+  interval: 90s
+- name: ifInDiscards
+  description: Test
+  oidStub: .1.3.6.1.2.1.2.2.1.13
+  mlabUplinkName: switch.discards.uplink.rx
+  mlabMachineName: switch.discards.local.rx
+`
+
+func TestMetricIntervals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMetricIntervals")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/interval.yaml", []byte(intervalYaml), 0644), "Could not write YAML to tempfile")
+	rtx.Must(ioutil.WriteFile(dir+"/bad.yaml", []byte(strings.Replace(intervalYaml, "90s", "1500ms", 1)), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(dir + "/interval.yaml")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if c.Metrics[0].Interval != time.Minute || c.Metrics[2].Interval != 0 {
+		t.Errorf("Unexpected intervals: %v and %v", c.Metrics[0].Interval, c.Metrics[2].Interval)
+	}
+	if err := c.ValidateIntervals(10 * time.Second); err != nil {
+		t.Errorf("Did not expect an error for a 10s poll interval, but got: %v", err)
+	}
+	err = c.ValidateIntervals(20 * time.Second)
+	if err == nil || err.Error() != "line 13: metric 'ifOutErrors': interval 1m30s is not a multiple of the poll interval 20s" {
+		t.Errorf("Unexpected error for a 20s poll interval: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	rtx.Must(c.Print(buf), "Could not print the config")
+	if !strings.Contains(buf.String(), "interval: 1m0s") {
+		t.Errorf("Expected the printed config to include the interval, got:\n%v", buf.String())
+	}
+
+	_, err = New(dir + "/bad.yaml")
+	if err == nil || !strings.Contains(err.Error(), "line 13: metric 'ifOutErrors': interval 1.5s is not a whole number of seconds") {
+		t.Errorf("Unexpected error for a fractional interval: %v", err)
+	}
+}
+
+func TestMetricTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMetricTypes")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/gauge.yaml", []byte(gaugeYaml), 0644), "Could not write YAML to tempfile")
+	rtx.Must(ioutil.WriteFile(dir+"/unknown.yaml", []byte(unknownTypeYaml), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(dir + "/gauge.yaml")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if c.Metrics[0].Type != Enum || c.Metrics[0].IsCounter() {
+		t.Errorf("Expected metric of type '%v' that is not a counter, but got: %v", Enum, c.Metrics[0].Type)
+	}
+
+	_, err = New(dir + "/unknown.yaml")
+	if err == nil {
+		t.Error("An unknown metric type should cause an error.")
+	}
+}
+
+func TestMetricModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMetricModes")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/table.yaml", []byte(tableYaml), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(dir + "/table.yaml")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	m := c.Metrics[0]
+	if m.Mode != Table {
+		t.Errorf("Expected mode '%v', but got: %v", Table, m.Mode)
+	}
+	if m.IndexLabel != "index" {
+		t.Errorf("Expected the default index label, but got: %v", m.IndexLabel)
+	}
+	if m.LabelOids["entPhysicalName"] != ".1.3.6.1.2.1.47.1.1.1.1.7" {
+		t.Errorf("Unexpected label OIDs: %v", m.LabelOids)
+	}
+}
+
+func TestSymbolicOids(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSymbolicOids")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/symbolic.yaml", []byte(symbolicYaml), 0644), "Could not write YAML to tempfile")
+	rtx.Must(ioutil.WriteFile(dir+"/unknown.yaml", []byte(unknownSymbolYaml), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(dir + "/symbolic.yaml")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	m := c.Metrics[0]
+	if m.OidStub != ".1.3.6.1.2.1.99.1.1.1.4" || m.OidName != "ENTITY-SENSOR-MIB::entPhySensorValue" {
+		t.Errorf("Unexpected resolution of oidStub: %v from %v", m.OidStub, m.OidName)
+	}
+	if m.LabelOids["entPhysicalName"] != ".1.3.6.1.2.1.47.1.1.1.1.7" {
+		t.Errorf("Unexpected resolution of labelOids: %v", m.LabelOids)
+	}
+
+	var b bytes.Buffer
+	rtx.Must(c.Print(&b), "Could not print config")
+	for _, expect := range []string{
+		"oidStub: .1.3.6.1.2.1.99.1.1.1.4",
+		"oidName: ENTITY-SENSOR-MIB::entPhySensorValue",
+		"entPhysicalName: ENTITY-MIB::entPhysicalName",
+	} {
+		if !strings.Contains(b.String(), expect) {
+			t.Errorf("Expected printed config to contain '%v', but got:\n%v", expect, b.String())
+		}
+	}
+
+	_, err = New(dir + "/unknown.yaml")
+	if err == nil {
+		t.Error("An unknown symbolic OID name should cause an error.")
+	}
+}
+
+func TestUndottedOids(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestUndottedOids")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/undotted.yaml", []byte(undottedYaml), 0644), "Could not write YAML to tempfile")
+
+	// The names of the PDUs returned by the switch have a leading dot, so
+	// numeric OIDs without one are given one.
+	c, err := New(dir + "/undotted.yaml")
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	m := c.Metrics[0]
+	if m.OidStub != ".1.3.6.1.2.1.99.1.1.1.4" || m.OidName != "" {
+		t.Errorf("Unexpected resolution of oidStub: %v from %q", m.OidStub, m.OidName)
+	}
+	if m.LabelOids["entPhysicalName"] != ".1.3.6.1.2.1.47.1.1.1.1.7" || len(m.LabelOidNames) != 0 {
+		t.Errorf("Unexpected resolution of labelOids: %v from %v", m.LabelOids, m.LabelOidNames)
+	}
+}
@@ -0,0 +1,359 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// standardMIBs are the objects of the MIB modules that are bundled with disco,
+// keyed by module and then object name.
+var standardMIBs = map[string]map[string]string{
+	"SNMPv2-SMI": {
+		"zeroDotZero":  ".0.0",
+		"iso":          ".1",
+		"org":          ".1.3",
+		"dod":          ".1.3.6",
+		"internet":     ".1.3.6.1",
+		"directory":    ".1.3.6.1.1",
+		"mgmt":         ".1.3.6.1.2",
+		"mib-2":        ".1.3.6.1.2.1",
+		"transmission": ".1.3.6.1.2.1.10",
+		"experimental": ".1.3.6.1.3",
+		"private":      ".1.3.6.1.4",
+		"enterprises":  ".1.3.6.1.4.1",
+		"security":     ".1.3.6.1.5",
+		"snmpV2":       ".1.3.6.1.6",
+		"snmpDomains":  ".1.3.6.1.6.1",
+		"snmpProxys":   ".1.3.6.1.6.2",
+		"snmpModules":  ".1.3.6.1.6.3",
+	},
+	"SNMPv2-MIB": {
+		"system":                  ".1.3.6.1.2.1.1",
+		"sysDescr":                ".1.3.6.1.2.1.1.1",
+		"sysObjectID":             ".1.3.6.1.2.1.1.2",
+		"sysUpTime":               ".1.3.6.1.2.1.1.3",
+		"sysContact":              ".1.3.6.1.2.1.1.4",
+		"sysName":                 ".1.3.6.1.2.1.1.5",
+		"sysLocation":             ".1.3.6.1.2.1.1.6",
+		"sysServices":             ".1.3.6.1.2.1.1.7",
+		"sysORLastChange":         ".1.3.6.1.2.1.1.8",
+		"snmp":                    ".1.3.6.1.2.1.11",
+		"snmpInPkts":              ".1.3.6.1.2.1.11.1",
+		"snmpOutPkts":             ".1.3.6.1.2.1.11.2",
+		"snmpInBadVersions":       ".1.3.6.1.2.1.11.3",
+		"snmpInBadCommunityNames": ".1.3.6.1.2.1.11.4",
+		"snmpInASNParseErrs":      ".1.3.6.1.2.1.11.6",
+		"snmpEnableAuthenTraps":   ".1.3.6.1.2.1.11.30",
+		"snmpSilentDrops":         ".1.3.6.1.2.1.11.31",
+		"snmpProxyDrops":          ".1.3.6.1.2.1.11.32",
+		"snmpMIB":                 ".1.3.6.1.6.3.1",
+		"snmpMIBObjects":          ".1.3.6.1.6.3.1.1",
+		"snmpTrap":                ".1.3.6.1.6.3.1.1.4",
+		"snmpTrapOID":             ".1.3.6.1.6.3.1.1.4.1",
+		"snmpTrapEnterprise":      ".1.3.6.1.6.3.1.1.4.3",
+		"snmpTraps":               ".1.3.6.1.6.3.1.1.5",
+		"coldStart":               ".1.3.6.1.6.3.1.1.5.1",
+		"warmStart":               ".1.3.6.1.6.3.1.1.5.2",
+		"authenticationFailure":   ".1.3.6.1.6.3.1.1.5.5",
+	},
+	"IF-MIB": {
+		"interfaces":                 ".1.3.6.1.2.1.2",
+		"ifNumber":                   ".1.3.6.1.2.1.2.1",
+		"ifTable":                    ".1.3.6.1.2.1.2.2",
+		"ifEntry":                    ".1.3.6.1.2.1.2.2.1",
+		"ifIndex":                    ".1.3.6.1.2.1.2.2.1.1",
+		"ifDescr":                    ".1.3.6.1.2.1.2.2.1.2",
+		"ifType":                     ".1.3.6.1.2.1.2.2.1.3",
+		"ifMtu":                      ".1.3.6.1.2.1.2.2.1.4",
+		"ifSpeed":                    ".1.3.6.1.2.1.2.2.1.5",
+		"ifPhysAddress":              ".1.3.6.1.2.1.2.2.1.6",
+		"ifAdminStatus":              ".1.3.6.1.2.1.2.2.1.7",
+		"ifOperStatus":               ".1.3.6.1.2.1.2.2.1.8",
+		"ifLastChange":               ".1.3.6.1.2.1.2.2.1.9",
+		"ifInOctets":                 ".1.3.6.1.2.1.2.2.1.10",
+		"ifInUcastPkts":              ".1.3.6.1.2.1.2.2.1.11",
+		"ifInNUcastPkts":             ".1.3.6.1.2.1.2.2.1.12",
+		"ifInDiscards":               ".1.3.6.1.2.1.2.2.1.13",
+		"ifInErrors":                 ".1.3.6.1.2.1.2.2.1.14",
+		"ifInUnknownProtos":          ".1.3.6.1.2.1.2.2.1.15",
+		"ifOutOctets":                ".1.3.6.1.2.1.2.2.1.16",
+		"ifOutUcastPkts":             ".1.3.6.1.2.1.2.2.1.17",
+		"ifOutNUcastPkts":            ".1.3.6.1.2.1.2.2.1.18",
+		"ifOutDiscards":              ".1.3.6.1.2.1.2.2.1.19",
+		"ifOutErrors":                ".1.3.6.1.2.1.2.2.1.20",
+		"ifOutQLen":                  ".1.3.6.1.2.1.2.2.1.21",
+		"ifSpecific":                 ".1.3.6.1.2.1.2.2.1.22",
+		"ifMIB":                      ".1.3.6.1.2.1.31",
+		"ifMIBObjects":               ".1.3.6.1.2.1.31.1",
+		"ifXTable":                   ".1.3.6.1.2.1.31.1.1",
+		"ifXEntry":                   ".1.3.6.1.2.1.31.1.1.1",
+		"ifName":                     ".1.3.6.1.2.1.31.1.1.1.1",
+		"ifInMulticastPkts":          ".1.3.6.1.2.1.31.1.1.1.2",
+		"ifInBroadcastPkts":          ".1.3.6.1.2.1.31.1.1.1.3",
+		"ifOutMulticastPkts":         ".1.3.6.1.2.1.31.1.1.1.4",
+		"ifOutBroadcastPkts":         ".1.3.6.1.2.1.31.1.1.1.5",
+		"ifHCInOctets":               ".1.3.6.1.2.1.31.1.1.1.6",
+		"ifHCInUcastPkts":            ".1.3.6.1.2.1.31.1.1.1.7",
+		"ifHCInMulticastPkts":        ".1.3.6.1.2.1.31.1.1.1.8",
+		"ifHCInBroadcastPkts":        ".1.3.6.1.2.1.31.1.1.1.9",
+		"ifHCOutOctets":              ".1.3.6.1.2.1.31.1.1.1.10",
+		"ifHCOutUcastPkts":           ".1.3.6.1.2.1.31.1.1.1.11",
+		"ifHCOutMulticastPkts":       ".1.3.6.1.2.1.31.1.1.1.12",
+		"ifHCOutBroadcastPkts":       ".1.3.6.1.2.1.31.1.1.1.13",
+		"ifLinkUpDownTrapEnable":     ".1.3.6.1.2.1.31.1.1.1.14",
+		"ifHighSpeed":                ".1.3.6.1.2.1.31.1.1.1.15",
+		"ifPromiscuousMode":          ".1.3.6.1.2.1.31.1.1.1.16",
+		"ifConnectorPresent":         ".1.3.6.1.2.1.31.1.1.1.17",
+		"ifAlias":                    ".1.3.6.1.2.1.31.1.1.1.18",
+		"ifCounterDiscontinuityTime": ".1.3.6.1.2.1.31.1.1.1.19",
+		"ifTableLastChange":          ".1.3.6.1.2.1.31.1.5",
+		"ifStackLastChange":          ".1.3.6.1.2.1.31.1.6",
+		"linkDown":                   ".1.3.6.1.6.3.1.1.5.3",
+		"linkUp":                     ".1.3.6.1.6.3.1.1.5.4",
+	},
+	"ENTITY-MIB": {
+		"entityMIB":               ".1.3.6.1.2.1.47",
+		"entityMIBObjects":        ".1.3.6.1.2.1.47.1",
+		"entityPhysical":          ".1.3.6.1.2.1.47.1.1",
+		"entPhysicalTable":        ".1.3.6.1.2.1.47.1.1.1",
+		"entPhysicalEntry":        ".1.3.6.1.2.1.47.1.1.1.1",
+		"entPhysicalIndex":        ".1.3.6.1.2.1.47.1.1.1.1.1",
+		"entPhysicalDescr":        ".1.3.6.1.2.1.47.1.1.1.1.2",
+		"entPhysicalVendorType":   ".1.3.6.1.2.1.47.1.1.1.1.3",
+		"entPhysicalContainedIn":  ".1.3.6.1.2.1.47.1.1.1.1.4",
+		"entPhysicalClass":        ".1.3.6.1.2.1.47.1.1.1.1.5",
+		"entPhysicalParentRelPos": ".1.3.6.1.2.1.47.1.1.1.1.6",
+		"entPhysicalName":         ".1.3.6.1.2.1.47.1.1.1.1.7",
+		"entPhysicalHardwareRev":  ".1.3.6.1.2.1.47.1.1.1.1.8",
+		"entPhysicalFirmwareRev":  ".1.3.6.1.2.1.47.1.1.1.1.9",
+		"entPhysicalSoftwareRev":  ".1.3.6.1.2.1.47.1.1.1.1.10",
+		"entPhysicalSerialNum":    ".1.3.6.1.2.1.47.1.1.1.1.11",
+		"entPhysicalMfgName":      ".1.3.6.1.2.1.47.1.1.1.1.12",
+		"entPhysicalModelName":    ".1.3.6.1.2.1.47.1.1.1.1.13",
+		"entPhysicalAlias":        ".1.3.6.1.2.1.47.1.1.1.1.14",
+		"entPhysicalAssetID":      ".1.3.6.1.2.1.47.1.1.1.1.15",
+		"entPhysicalIsFRU":        ".1.3.6.1.2.1.47.1.1.1.1.16",
+		"entPhysicalMfgDate":      ".1.3.6.1.2.1.47.1.1.1.1.17",
+		"entPhysicalUris":         ".1.3.6.1.2.1.47.1.1.1.1.18",
+		"entLastChangeTime":       ".1.3.6.1.2.1.47.1.4.1",
+	},
+	"ENTITY-SENSOR-MIB": {
+		"entitySensorMIB":             ".1.3.6.1.2.1.99",
+		"entitySensorObjects":         ".1.3.6.1.2.1.99.1",
+		"entPhySensorTable":           ".1.3.6.1.2.1.99.1.1",
+		"entPhySensorEntry":           ".1.3.6.1.2.1.99.1.1.1",
+		"entPhySensorType":            ".1.3.6.1.2.1.99.1.1.1.1",
+		"entPhySensorScale":           ".1.3.6.1.2.1.99.1.1.1.2",
+		"entPhySensorPrecision":       ".1.3.6.1.2.1.99.1.1.1.3",
+		"entPhySensorValue":           ".1.3.6.1.2.1.99.1.1.1.4",
+		"entPhySensorOperStatus":      ".1.3.6.1.2.1.99.1.1.1.5",
+		"entPhySensorUnitsDisplay":    ".1.3.6.1.2.1.99.1.1.1.6",
+		"entPhySensorValueTimeStamp":  ".1.3.6.1.2.1.99.1.1.1.7",
+		"entPhySensorValueUpdateRate": ".1.3.6.1.2.1.99.1.1.1.8",
+	},
+}
+
+var (
+	numericOIDRegexp = regexp.MustCompile(`^\.?[0-9]+(\.[0-9]+)*$`)
+	mibModuleRegexp  = regexp.MustCompile(`([A-Za-z][\w-]*)\s+DEFINITIONS\s*::=\s*BEGIN`)
+	// mibObjectRegexp matches the definition of a node in the OID tree, e.g.
+	// "ifEntry OBJECT-TYPE ... ::= { ifTable 1 }" or
+	// "ifMIB MODULE-IDENTITY ... ::= { mib-2 31 }" or
+	// "juniperMIB OBJECT IDENTIFIER ::= { enterprises 2636 }".
+	mibObjectRegexp  = regexp.MustCompile(`(?s)([a-z][\w-]*)\s+(?:OBJECT\s+IDENTIFIER\s*|(?:OBJECT-TYPE|MODULE-IDENTITY|OBJECT-IDENTITY|NOTIFICATION-TYPE|OBJECT-GROUP|NOTIFICATION-GROUP|MODULE-COMPLIANCE|AGENT-CAPABILITIES)\b.*?)::=\s*\{([^}]*)\}`)
+	mibCommentRegexp = regexp.MustCompile(`--.*?(--|\n)`)
+	mibStringRegexp  = regexp.MustCompile(`(?s)"[^"]*"`)
+	mibSubIDRegexp   = regexp.MustCompile(`^(?:[a-z][\w-]*\()?([0-9]+)\)?$`)
+)
+
+// MIBs resolves symbolic OID names, e.g. IF-MIB::ifHCInOctets, to numeric OIDs
+// using the bundled standard MIB modules plus any user supplied MIB files.
+type MIBs struct {
+	modules map[string]map[string]string
+}
+
+// mibObject is a node in the OID tree that has not yet been resolved.
+type mibObject struct {
+	module string
+	name   string
+	parent string
+	subIDs []string
+}
+
+// NewMIBs returns a new MIBs loaded with the standard MIB modules and the
+// modules defined in files.
+func NewMIBs(files ...string) (*MIBs, error) {
+	m := &MIBs{
+		modules: make(map[string]map[string]string),
+	}
+	for module, objects := range standardMIBs {
+		m.modules[module] = make(map[string]string)
+		for name, oid := range objects {
+			m.modules[module][name] = oid
+		}
+	}
+
+	pending := []mibObject{}
+	for _, file := range files {
+		objects, err := parseMIB(file)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, objects...)
+	}
+
+	// Objects may be defined relative to objects that appear later in a file,
+	// or in another file, so keep resolving until no more progress is made.
+	for len(pending) > 0 {
+		unresolved := []mibObject{}
+		for _, o := range pending {
+			parent, ok := m.lookup(o.module, o.parent)
+			if !ok {
+				unresolved = append(unresolved, o)
+				continue
+			}
+			if m.modules[o.module] == nil {
+				m.modules[o.module] = make(map[string]string)
+			}
+			m.modules[o.module][o.name] = parent + "." + strings.Join(o.subIDs, ".")
+		}
+		if len(unresolved) == len(pending) {
+			o := unresolved[0]
+			return nil, fmt.Errorf("cannot resolve parent '%v' of %v::%v (and %v other objects); is a MIB file missing?",
+				o.parent, o.module, o.name, len(unresolved)-1)
+		}
+		pending = unresolved
+	}
+
+	return m, nil
+}
+
+// parseMIB returns the OID tree nodes defined in a MIB file.
+func parseMIB(file string) ([]mibObject, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MIB file '%v': %v", file, err)
+	}
+
+	// Descriptions may contain anything, including text that looks like a
+	// definition, so they are removed along with comments before parsing.
+	text := mibStringRegexp.ReplaceAllString(string(data), `""`)
+	text = mibCommentRegexp.ReplaceAllString(text, "\n")
+
+	match := mibModuleRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return nil, fmt.Errorf("no module definition found in MIB file '%v'", file)
+	}
+	module := match[1]
+
+	objects := []mibObject{}
+	for _, def := range mibObjectRegexp.FindAllStringSubmatch(text, -1) {
+		fields := strings.Fields(def[2])
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid OID value for %v::%v in MIB file '%v'", module, def[1], file)
+		}
+		o := mibObject{
+			module: module,
+			name:   def[1],
+			parent: fields[0],
+		}
+		for _, field := range fields[1:] {
+			subID := mibSubIDRegexp.FindStringSubmatch(field)
+			if subID == nil {
+				return nil, fmt.Errorf("invalid OID value for %v::%v in MIB file '%v'", module, def[1], file)
+			}
+			o.subIDs = append(o.subIDs, subID[1])
+		}
+		objects = append(objects, o)
+	}
+
+	return objects, nil
+}
+
+// lookup finds the numeric OID of a name, preferring a definition in module
+// over those in any other module, since names are usually imported.
+func (m *MIBs) lookup(module, name string) (string, bool) {
+	if oid, ok := m.modules[module][name]; ok {
+		return oid, true
+	}
+	if oid, ok := m.modules["SNMPv2-SMI"][name]; ok {
+		return oid, true
+	}
+	modules := []string{}
+	for mod := range m.modules {
+		modules = append(modules, mod)
+	}
+	sort.Strings(modules)
+	for _, mod := range modules {
+		if oid, ok := m.modules[mod][name]; ok {
+			return oid, true
+		}
+	}
+	return "", false
+}
+
+// IsNumericOID reports whether oid is a numeric OID, e.g. .1.3.6.1.2.1.1.3.0.
+func IsNumericOID(oid string) bool {
+	return numericOIDRegexp.MatchString(oid)
+}
+
+// Resolve returns the numeric OID for name. Numeric OIDs are returned with a
+// leading dot, added if it is missing, as the names of the PDUs returned by
+// the switch always have one. Symbolic names may be qualified with a module
+// and may have a numeric instance suffix, e.g. "IF-MIB::ifHCInOctets",
+// "SNMPv2-MIB::sysUpTime.0" or "ifHCInOctets".
+func (m *MIBs) Resolve(name string) (string, error) {
+	if IsNumericOID(name) {
+		if !strings.HasPrefix(name, ".") {
+			return "." + name, nil
+		}
+		return name, nil
+	}
+
+	module := ""
+	object := name
+	if i := strings.Index(name, "::"); i >= 0 {
+		module = name[:i]
+		object = name[i+2:]
+		if _, ok := m.modules[module]; !ok {
+			return "", fmt.Errorf("unknown MIB module '%v' in '%v'", module, name)
+		}
+	}
+
+	suffix := ""
+	if i := strings.Index(object, "."); i >= 0 {
+		object, suffix = object[:i], object[i:]
+		if !IsNumericOID(suffix) {
+			return "", fmt.Errorf("invalid instance '%v' in '%v'", suffix, name)
+		}
+	}
+
+	if module != "" {
+		oid, ok := m.modules[module][object]
+		if !ok {
+			return "", fmt.Errorf("unknown object '%v' in MIB module '%v'", object, module)
+		}
+		return oid + suffix, nil
+	}
+
+	found := []string{}
+	oid := ""
+	for mod, objects := range m.modules {
+		if o, ok := objects[object]; ok {
+			if oid != "" && o != oid {
+				found = append(found, mod)
+				sort.Strings(found)
+				return "", fmt.Errorf("ambiguous name '%v' is defined in MIB modules %v", name, strings.Join(found, ", "))
+			}
+			oid = o
+			found = append(found, mod)
+		}
+	}
+	if oid == "" {
+		return "", fmt.Errorf("unknown OID name '%v'", name)
+	}
+	return oid + suffix, nil
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+var testMIB = `
+TEST-SWITCH-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    MODULE-IDENTITY, OBJECT-TYPE, Integer32, enterprises
+        FROM SNMPv2-SMI;
+
+-- testOpticsTable OBJECT-TYPE ::= { testSwitchMIB 99 }
+
+testOpticsRxPower OBJECT-TYPE
+    SYNTAX      Integer32
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION
+        "Received power in hundredths of a dBm. Not to be confused with
+        testOpticsTxPower OBJECT-TYPE ::= { testOpticsEntry 3 }."
+    ::= { testOpticsEntry 2 }
+
+testOpticsEntry OBJECT-TYPE
+    SYNTAX      TestOpticsEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "An entry."
+    INDEX       { ifIndex }
+    ::= { testOpticsTable 1 }
+
+testOpticsTable OBJECT-TYPE
+    SYNTAX      SEQUENCE OF TestOpticsEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "Optics."
+    ::= { testSwitchObjects 1 }
+
+testSwitchMIB MODULE-IDENTITY
+    LAST-UPDATED "202006110000Z"
+    ORGANIZATION "Test"
+    CONTACT-INFO "Test"
+    DESCRIPTION  "Test"
+    ::= { enterprises 99999 }
+
+testSwitchObjects OBJECT IDENTIFIER ::= { testSwitchMIB 1 }
+testSwitchIso OBJECT IDENTIFIER ::= { iso org(3) dod(6) 1 4 1 99998 }
+
+END
+`
+
+var missingParentMIB = `
+TEST-BROKEN-MIB DEFINITIONS ::= BEGIN
+testBroken OBJECT IDENTIFIER ::= { doesNotExist 1 }
+END
+`
+
+func TestResolveStandard(t *testing.T) {
+	mibs, err := NewMIBs()
+	rtx.Must(err, "Could not load the standard MIBs")
+
+	tests := []struct {
+		name   string
+		expect string
+	}{
+		{name: ".1.3.6.1.2.1.31.1.1.1.6", expect: ".1.3.6.1.2.1.31.1.1.1.6"},
+		{name: "1.3.6.1.2.1.31.1.1.1.6", expect: ".1.3.6.1.2.1.31.1.1.1.6"},
+		{name: "IF-MIB::ifHCInOctets", expect: ".1.3.6.1.2.1.31.1.1.1.6"},
+		{name: "ifHCOutOctets", expect: ".1.3.6.1.2.1.31.1.1.1.10"},
+		{name: "SNMPv2-MIB::sysUpTime.0", expect: ".1.3.6.1.2.1.1.3.0"},
+		{name: "ENTITY-MIB::entPhysicalName", expect: ".1.3.6.1.2.1.47.1.1.1.1.7"},
+	}
+	for _, tt := range tests {
+		oid, err := mibs.Resolve(tt.name)
+		if err != nil {
+			t.Errorf("Did not expect an error resolving '%v', but got: %v", tt.name, err)
+		}
+		if oid != tt.expect {
+			t.Errorf("Expected '%v' to resolve to %v, but got: %v", tt.name, tt.expect, oid)
+		}
+	}
+
+	for _, name := range []string{"IF-MIB::ifHCInOctetz", "NO-SUCH-MIB::ifHCInOctets", "ifNoSuchThing", "sysUpTime.zero"} {
+		_, err := mibs.Resolve(name)
+		if err == nil {
+			t.Errorf("Expected an error resolving '%v', but didn't get one", name)
+		}
+	}
+}
+
+func TestResolveMIBFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestResolveMIBFile")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/TEST-SWITCH-MIB.txt", []byte(testMIB), 0644), "Could not write MIB to tempfile")
+	rtx.Must(ioutil.WriteFile(dir+"/TEST-BROKEN-MIB.txt", []byte(missingParentMIB), 0644), "Could not write MIB to tempfile")
+
+	mibs, err := NewMIBs(dir + "/TEST-SWITCH-MIB.txt")
+	if err != nil {
+		t.Fatalf("Did not expect an error loading a MIB file, but got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		expect string
+	}{
+		{name: "TEST-SWITCH-MIB::testOpticsRxPower", expect: ".1.3.6.1.4.1.99999.1.1.1.2"},
+		{name: "testOpticsTable", expect: ".1.3.6.1.4.1.99999.1.1"},
+		{name: "TEST-SWITCH-MIB::testSwitchIso", expect: ".1.3.6.1.4.1.99998"},
+	}
+	for _, tt := range tests {
+		oid, err := mibs.Resolve(tt.name)
+		if err != nil {
+			t.Errorf("Did not expect an error resolving '%v', but got: %v", tt.name, err)
+		}
+		if oid != tt.expect {
+			t.Errorf("Expected '%v' to resolve to %v, but got: %v", tt.name, tt.expect, oid)
+		}
+	}
+
+	// Neither commented out definitions nor descriptions should be parsed.
+	if _, err := mibs.Resolve("testOpticsTxPower"); err == nil {
+		t.Error("Expected an error resolving an object named only in a description")
+	}
+
+	if _, err := NewMIBs(dir + "/TEST-BROKEN-MIB.txt"); err == nil {
+		t.Error("Expected an error loading a MIB with an unresolvable parent")
+	}
+	if _, err := NewMIBs(dir + "/does-not-exist.txt"); err == nil {
+		t.Error("Expected an error loading a missing MIB file")
+	}
+}
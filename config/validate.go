@@ -0,0 +1,267 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	metricNameRegexp  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	symbolicOIDRegexp = regexp.MustCompile(`^([A-Za-z][\w-]*::)?[A-Za-z][\w-]*(\.[0-9]+)*$`)
+)
+
+// reservedPrefixes are the prefixes of the names of the metrics that DISCOv2
+// and the Prometheus client register themselves, which a configured metric
+// can't share without failing to register.
+var reservedPrefixes = []string{"disco_", "go_", "process_", "promhttp_"}
+
+// lineNumbers maps the YAML keys of a metric to the line they appear on. The
+// empty key holds the line on which the metric itself starts.
+type lineNumbers map[string]int
+
+// ValidationError describes a single problem with a metrics config.
+type ValidationError struct {
+	// Line is the line of the YAML file the problem was found on, or zero if
+	// unknown.
+	Line int
+	// Metric is the name of the metric with the problem, if it has one.
+	Metric  string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	msg := e.Message
+	if e.Metric != "" {
+		msg = fmt.Sprintf("metric '%v': %v", e.Metric, msg)
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %v: %v", e.Line, msg)
+	}
+	return msg
+}
+
+// ValidationErrors is a list of all the problems found with a metrics config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// metricLines returns the line numbers of the keys of each metric in the YAML
+// data. Problems parsing the data are reported when it is unmarshalled, so
+// they are ignored here.
+func metricLines(yamlData []byte) []lineNumbers {
+	var doc yaml.Node
+	if yaml.Unmarshal(yamlData, &doc) != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	lines := []lineNumbers{}
+	for _, item := range doc.Content[0].Content {
+		l := lineNumbers{"": item.Line}
+		// Mapping nodes hold their keys and values as alternating children.
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			l[item.Content[i].Value] = item.Content[i].Line
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// line returns the line of the given key of the i'th metric, falling back to
+// the line the metric starts on if the key is not present.
+func (c Config) line(i int, key string) int {
+	if i >= len(c.lines) {
+		return 0
+	}
+	if l, ok := c.lines[i][key]; ok {
+		return l
+	}
+	return c.lines[i][""]
+}
+
+// validationError returns a ValidationError for the given key of the i'th
+// metric.
+func (c Config) validationError(i int, key string, format string, args ...interface{}) ValidationError {
+	return ValidationError{
+		Line:    c.line(i, key),
+		Metric:  c.Metrics[i].Name,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// isOID reports whether oid is a numeric OID or looks like a symbolic name.
+func isOID(oid string) bool {
+	return IsNumericOID(oid) || symbolicOIDRegexp.MatchString(oid)
+}
+
+// hasReservedPrefix reports whether name starts with one of reservedPrefixes.
+func hasReservedPrefix(name string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate checks the config for problems that would prevent it from being
+// collected or archived correctly, such as missing fields, malformed OIDs and
+// duplicate Prometheus or archive metric names. It returns a ValidationErrors
+// listing every problem found, or nil if there are none.
+func (c Config) Validate() error {
+	errs := ValidationErrors{}
+
+	if len(c.Metrics) == 0 {
+		errs = append(errs, ValidationError{Message: "no metrics are defined"})
+	}
+
+	// The index of the metric each Prometheus and archive name, and each
+	// oidStub, was first used by, for reporting duplicates.
+	names := make(map[string]int)
+	archiveNames := make(map[string]int)
+	oidStubs := make(map[string]int)
+	checkArchiveName := func(i int, key string, name string, mode string) {
+		if name == "" {
+			errs = append(errs, c.validationError(i, key, "%v is required for %v metrics", key, mode))
+			return
+		}
+		if first, ok := archiveNames[name]; ok {
+			errs = append(errs, c.validationError(i, key, "duplicate archive metric name '%v', first used by metric '%v' on line %v",
+				name, c.Metrics[first].Name, c.line(first, "")))
+			return
+		}
+		archiveNames[name] = i
+	}
+
+	for i, m := range c.Metrics {
+		switch {
+		case m.Name == "":
+			errs = append(errs, c.validationError(i, "name", "name is required"))
+		case !metricNameRegexp.MatchString(m.Name):
+			errs = append(errs, c.validationError(i, "name", "'%v' is not a valid Prometheus metric name", m.Name))
+		case hasReservedPrefix(m.Name):
+			errs = append(errs, c.validationError(i, "name", "names starting with %v or %v are reserved for the metrics of DISCOv2 itself",
+				strings.Join(reservedPrefixes[:len(reservedPrefixes)-1], ", "), reservedPrefixes[len(reservedPrefixes)-1]))
+		default:
+			if first, ok := names[m.Name]; ok {
+				errs = append(errs, c.validationError(i, "name", "duplicate name, first used on line %v", c.line(first, "")))
+			} else {
+				names[m.Name] = i
+			}
+		}
+
+		switch m.Type {
+		case "", Counter, Gauge, Enum, TimeTicks:
+		default:
+			errs = append(errs, c.validationError(i, "type", "unknown type '%v', must be one of %v, %v, %v or %v",
+				m.Type, Counter, Gauge, Enum, TimeTicks))
+		}
+
+		switch {
+		case m.OidStub == "":
+			errs = append(errs, c.validationError(i, "oidStub", "oidStub is required"))
+		case !isOID(m.OidStub):
+			errs = append(errs, c.validationError(i, "oidStub", "malformed OID '%v'", m.OidStub))
+		default:
+			// Interface and table metrics both collect the rows below their
+			// oidStub, so either would replace the series of the other.
+			key := "rows " + m.OidStub
+			if m.Mode == Scalar {
+				key = "scalar " + m.OidStub
+			}
+			if first, ok := oidStubs[key]; ok {
+				errs = append(errs, c.validationError(i, "oidStub", "oidStub '%v' is already collected by metric '%v' on line %v",
+					m.OidStub, c.Metrics[first].Name, c.line(first, "")))
+			} else {
+				oidStubs[key] = i
+			}
+		}
+
+		switch m.Mode {
+		case "", Interface:
+			checkArchiveName(i, "mlabUplinkName", m.MlabUplinkName, Interface)
+			checkArchiveName(i, "mlabMachineName", m.MlabMachineName, Interface)
+			if m.MlabName != "" {
+				errs = append(errs, c.validationError(i, "mlabName", "mlabName is only used by %v and %v metrics", Scalar, Table))
+			}
+		case Scalar, Table:
+			checkArchiveName(i, "mlabName", m.MlabName, m.Mode)
+			if m.MlabUplinkName != "" {
+				errs = append(errs, c.validationError(i, "mlabUplinkName", "mlabUplinkName is only used by %v metrics", Interface))
+			}
+			if m.MlabMachineName != "" {
+				errs = append(errs, c.validationError(i, "mlabMachineName", "mlabMachineName is only used by %v metrics", Interface))
+			}
+		default:
+			errs = append(errs, c.validationError(i, "mode", "unknown mode '%v', must be one of %v, %v or %v",
+				m.Mode, Interface, Scalar, Table))
+		}
+
+		switch {
+		case m.Interval < 0:
+			errs = append(errs, c.validationError(i, "interval", "interval must not be negative"))
+		case m.Interval%time.Second != 0:
+			errs = append(errs, c.validationError(i, "interval", "interval %v is not a whole number of seconds", m.Interval))
+		}
+
+		if m.Mode != Table {
+			if m.IndexLabel != "" || len(m.LabelOids) > 0 {
+				errs = append(errs, c.validationError(i, "mode", "indexLabel and labelOids are only used by %v metrics", Table))
+			}
+			continue
+		}
+		if m.IndexLabel != "" && (!labelNameRegexp.MatchString(m.IndexLabel) || m.IndexLabel == "node") {
+			errs = append(errs, c.validationError(i, "indexLabel", "'%v' is not a valid label name", m.IndexLabel))
+		}
+		for _, label := range sortedKeys(m.LabelOids) {
+			switch {
+			case !labelNameRegexp.MatchString(label) || label == "node" || label == m.IndexLabel:
+				errs = append(errs, c.validationError(i, "labelOids", "'%v' is not a valid label name", label))
+			case !isOID(m.LabelOids[label]):
+				errs = append(errs, c.validationError(i, "labelOids", "malformed OID '%v' for label '%v'", m.LabelOids[label], label))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateIntervals checks that the interval of every metric is a multiple of
+// the poll interval, since metrics are only collected when polling. It
+// returns a ValidationErrors listing every metric that isn't, or nil if there
+// are none.
+func (c Config) ValidateIntervals(poll time.Duration) error {
+	errs := ValidationErrors{}
+	for i, m := range c.Metrics {
+		if m.Interval > 0 && m.Interval%poll != 0 {
+			errs = append(errs, c.validationError(i, "interval", "interval %v is not a multiple of the poll interval %v", m.Interval, poll))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
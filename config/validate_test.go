@@ -0,0 +1,108 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+var invalidYaml = `
+- name: ifHCInOctets
+  description: Ingress octets.
+  oidStub: IF-MIB::ifHCInOctets
+  mlabUplinkName: switch.octets.uplink.rx
+  mlabMachineName: switch.octets.local.tx
+- name: ifHCOutOctets
+  description: Egress octets.
+  oidStub: IF-MIB::ifHCOutOctets
+  mlabUplinkName: switch.octets.uplink.tx
+  mlabMachineName: switch.octets.local.tx
+- name: ifHCInOctets
+  description: Duplicate.
+  type: histogram
+  oidStub: .1.3.6..1
+  mlabUplinkName: switch.octets.uplink.dup
+  mlabMachineName: switch.octets.local.dup
+- name: ""
+  description: Missing fields.
+  mode: scalar
+  oidStub: IF-MIB::ifNoSuchThing
+- name: ifOperStatus
+  description: Wrong mode fields.
+  mode: scalar
+  oidStub: IF-MIB::ifOperStatus.1
+  mlabName: switch.status
+  labelOids:
+    ifDescr: IF-MIB::ifDescr
+- name: ifInOctets
+  description: Same OIDs as ifHCInOctets.
+  oidStub: .1.3.6.1.2.1.31.1.1.1.6
+  mlabUplinkName: switch.inoctets.uplink.rx
+  mlabMachineName: switch.inoctets.local.rx
+- name: disco_snmp_up
+  description: Same name as a metric of DISCOv2.
+  mode: scalar
+  oidStub: SNMPv2-MIB::sysUpTime.0
+  mlabName: switch.up
+`
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestValidate")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/metrics.yaml", []byte(invalidYaml), 0644), "Could not write YAML to tempfile")
+
+	_, err = New(dir + "/metrics.yaml")
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected ValidationErrors, but got: %v", err)
+	}
+
+	expected := []string{
+		"line 11: metric 'ifHCOutOctets': duplicate archive metric name 'switch.octets.local.tx', first used by metric 'ifHCInOctets' on line 2",
+		"line 12: metric 'ifHCInOctets': duplicate name, first used on line 2",
+		"line 14: metric 'ifHCInOctets': unknown type 'histogram', must be one of counter, gauge, enum or timeticks",
+		"line 15: metric 'ifHCInOctets': malformed OID '.1.3.6..1'",
+		"line 18: name is required",
+		"line 18: mlabName is required for scalar metrics",
+		"line 21: unknown object 'ifNoSuchThing' in MIB module 'IF-MIB'",
+		"line 24: metric 'ifOperStatus': indexLabel and labelOids are only used by table metrics",
+		"line 31: metric 'ifInOctets': oidStub '.1.3.6.1.2.1.31.1.1.1.6' is already collected by metric 'ifHCInOctets' on line 2",
+		"line 34: metric 'disco_snmp_up': names starting with disco_, go_, process_ or promhttp_ are reserved for the metrics of DISCOv2 itself",
+	}
+	if errs.Error() != strings.Join(expected, "\n") {
+		t.Errorf("Expected errors:\n%v\nbut got:\n%v", strings.Join(expected, "\n"), errs)
+	}
+}
+
+func TestValidateWithoutLines(t *testing.T) {
+	c := Config{
+		Metrics: []Metric{
+			{Name: "sysUpTime", Mode: Scalar, OidStub: ".1.3.6.1.2.1.1.3.0", MlabName: "switch.uptime"},
+			{Name: "sysUpTime", Mode: Scalar, OidStub: ".1.3.6.1.2.1.1.3.0", MlabName: "switch.uptime"},
+		},
+	}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected an error but didn't get one")
+	}
+	if err.Error() != "metric 'sysUpTime': duplicate name, first used on line 0\n"+
+		"metric 'sysUpTime': oidStub '.1.3.6.1.2.1.1.3.0' is already collected by metric 'sysUpTime' on line 0\n"+
+		"metric 'sysUpTime': duplicate archive metric name 'switch.uptime', first used by metric 'sysUpTime' on line 0" {
+		t.Errorf("Unexpected errors: %v", err)
+	}
+
+	if (Config{}).Validate() == nil {
+		t.Error("Expected an error for a config without metrics, but didn't get one")
+	}
+}
+
+func TestRepoMetricsFile(t *testing.T) {
+	_, err := New("../metrics.yaml")
+	if err != nil {
+		t.Errorf("The metrics.yaml in this repo should be valid, but got: %v", err)
+	}
+}
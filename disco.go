@@ -3,73 +3,549 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
-	"github.com/go-co-op/gocron"
+	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
+	"github.com/nkinkade/disco-go/archive"
+	"github.com/nkinkade/disco-go/clock"
 	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/health"
+	"github.com/nkinkade/disco-go/logging"
 	"github.com/nkinkade/disco-go/metrics"
+	"github.com/nkinkade/disco-go/scheduler"
 	"github.com/nkinkade/disco-go/snmp"
 	"github.com/soniah/gosnmp"
 )
 
+// systemOid is the SNMPv2-MIB system group, which identifies the vendor and
+// model of a switch.
+const systemOid = ".1.3.6.1.2.1.1"
+
+// envPrefix is the prefix of the environment variables that flags can be set
+// with, e.g. DISCO_POLL_INTERVAL for --poll-interval.
+const envPrefix = "DISCO_"
+
+// notFromEnv holds the flags that are actions rather than settings, and so
+// are never set from the environment. A deployment may well set a variable
+// such as DISCO_VERSION for its own purposes.
+var notFromEnv = map[string]bool{"version": true}
+
 var (
 	community           = os.Getenv("DISCO_COMMUNITY")
-	fListenAddress      = flag.String("listen-address", ":8888", "Address to listen on for telemetry.")
+	fListenAddress      = flag.String("listen-address", ":9990", "Address to serve Prometheus metrics, health checks and the admin page on.")
+	fLogLevel           = flag.String("log-level", "info", "Minimum level of the messages to log, as JSON to stderr: debug, info, warn or error.")
+	fCommunityFile      = flag.String("community-file", "", "Path to a file containing the SNMP community, such as a mounted secret. The file is read again whenever it changes.")
+	fV3CredentialsFile  = flag.String("snmpv3-credentials-file", "", "Path to a YAML file of SNMPv3 credentials, such as a mounted secret. The file is read again whenever it changes.")
 	fMetricsFile        = flag.String("metrics", "", "Path to YAML file defining metrics to scrape.")
+	fMetricsCheck       = flag.Duration("metrics-check-interval", 0, "Interval at which to check the metrics file for changes and reload it. Zero disables checking, but the file is always reloaded on SIGHUP.")
+	fMIBFiles           flagx.StringArray
+	fSNMPPort           = flag.Uint("snmp-port", snmp.DefaultOptions.Port, "Port of the SNMP agent on the switch.")
+	fSNMPTimeout        = flag.Duration("snmp-timeout", snmp.DefaultOptions.Timeout, "Timeout of each SNMP request.")
+	fSNMPRetries        = flag.Int("snmp-retries", snmp.DefaultOptions.Retries, "Number of times to retry an SNMP request that timed out.")
+	fSNMPExpTimeout     = flag.Bool("snmp-exponential-timeout", false, "Double the SNMP timeout with each retry.")
+	fSNMPTransport      = flag.String("snmp-transport", snmp.DefaultOptions.Transport, "Transport to reach the SNMP agent over: udp, udp4, udp6, tcp, tcp4 or tcp6.")
+	fSNMPSource         = flag.String("snmp-source-address", "", "Local IP address to send SNMP requests from. By default the operating system chooses one.")
+	fSNMPVersion        = flag.String("snmp-version", snmp.DefaultOptions.Version, "Version of SNMP to use with a community: 1 or 2c. SNMPv3 is used with --snmpv3-credentials-file.")
+	fMaxOids            = flag.Int("snmp-max-oids", gosnmp.MaxOids, "Maximum number of OIDs to request in a single SNMP GET. Requests are split further if the switch replies that the response is too big.")
+	fPrintConfig        = flag.Bool("print-config", false, "Print the metrics configuration, with OIDs resolved, and exit.")
+	fPollInterval       = flag.Duration("poll-interval", 10*time.Second, "Interval at which to poll the switch, on multiples of which polls start. A poll that hasn't finished by the time the next one is due is abandoned. Metrics may set a longer interval of their own.")
+	fAdminActions       = flag.Bool("admin-actions", false, "Allow the rediscovery of interfaces and the flushing of the archive by POST requests to the admin endpoints.")
+	fReadyPolls         = flag.Int("ready-poll-intervals", 3, "Number of poll intervals within which a poll must have completed for /readyz to report ready.")
 	fWriteInterval      = flag.Uint64("write-interval", 300, "Interval in seconds to write out JSON files.")
 	fTarget             = flag.String("target", "", "Switch FQDN to scrape metrics from.")
+	fHostname           = flag.String("hostname", "", "Hostname of the machine whose switch port metrics are collected for, the first five characters of which are the ifAlias of the port. Defaults to the hostname of the system.")
+	fRecordFile         = flag.String("record-file", "", "Path to write the fixture recorded by the record command to. Defaults to stdout.")
+	fRecordOids         flagx.StringArray
+	fTrapListenAddress  = flag.String("trap-listen-address", "", "UDP address to receive SNMP traps and informs from the switch on, such as :162. Empty disables receiving notifications.")
+	fTrapNotifications  flagx.StringArray
+	fVersion            = flag.Bool("version", false, "Print the version of disco and exit.")
 	logFatal            = log.Fatal
+	osHostname          = os.Hostname
 	mainCtx, mainCancel = context.WithCancel(context.Background())
+	systemClock         = clock.Real{}
 )
 
+func init() {
+	flag.Var(&fMIBFiles, "mib-file", "Path to a MIB file defining symbolic OID names used in the metrics file. Can be repeated.")
+	flag.Var(&fRecordOids, "record-oid", "Numeric OID of an additional subtree for the record command to walk. Can be repeated.")
+	flag.Var(&fTrapNotifications, "trap-notification", "Numeric OID or symbolic name of a notification to archive, in addition to linkUp and linkDown. Can be repeated.")
+}
+
+// loadConfig loads the metrics configuration, checking that the interval of
+// each metric is a multiple of --poll-interval.
+func loadConfig() (config.Config, error) {
+	if *fPollInterval < time.Second || *fPollInterval%time.Second != 0 {
+		return config.Config{}, fmt.Errorf("--poll-interval must be a whole number of seconds")
+	}
+	c, err := config.New(*fMetricsFile, fMIBFiles...)
+	if err != nil {
+		return c, err
+	}
+	return c, c.ValidateIntervals(*fPollInterval)
+}
+
+// validateConfig loads and validates the metrics configuration, printing any
+// problems found, and returns the exit status for the validate-config mode.
+func validateConfig() int {
+	_, err := loadConfig()
+	if errs, ok := err.(config.ValidationErrors); ok {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", *fMetricsFile, e)
+		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", *fMetricsFile, err)
+		return 1
+	}
+	fmt.Printf("%v: OK\n", *fMetricsFile)
+	return 0
+}
+
+// snmpCredentials returns the source of the SNMP credentials: an SNMPv3
+// credentials file, a community file or the DISCO_COMMUNITY environment
+// variable.
+func snmpCredentials() (func() (snmp.Credentials, error), error) {
+	switch {
+	case *fV3CredentialsFile != "" && *fCommunityFile != "":
+		return nil, fmt.Errorf("--snmpv3-credentials-file and --community-file cannot both be set")
+	case *fV3CredentialsFile != "":
+		f, err := snmp.NewCredentialsFile(*fV3CredentialsFile, true)
+		if err != nil {
+			return nil, err
+		}
+		return f.Get, nil
+	case *fCommunityFile != "":
+		f, err := snmp.NewCredentialsFile(*fCommunityFile, false)
+		if err != nil {
+			return nil, err
+		}
+		return f.Get, nil
+	case community != "":
+		creds := snmp.Credentials{Community: community}
+		return func() (snmp.Credentials, error) { return creds, nil }, nil
+	}
+	return nil, fmt.Errorf("no SNMP credentials: set DISCO_COMMUNITY, --community-file or --snmpv3-credentials-file")
+}
+
+// connect returns an SNMP client connected to the target switch, configured by
+// the --snmp-* flags and the SNMP credentials. The operations the switch
+// supports are probed, and walks fall back to GetNext if it has no GetBulk.
+func connect(ctx context.Context) (*snmp.Managed, error) {
+	credentials, err := snmpCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the SNMP credentials: %v", err)
+	}
+
+	options := snmp.Options{
+		Port:               *fSNMPPort,
+		Timeout:            *fSNMPTimeout,
+		Retries:            *fSNMPRetries,
+		ExponentialTimeout: *fSNMPExpTimeout,
+		Transport:          *fSNMPTransport,
+		SourceAddress:      *fSNMPSource,
+		Version:            *fSNMPVersion,
+	}
+	err = options.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNMP options: %v", err)
+	}
+	if options.Version == "1" && *fV3CredentialsFile != "" {
+		return nil, fmt.Errorf("--snmp-version 1 cannot be used with --snmpv3-credentials-file")
+	}
+
+	goSNMP := options.GoSNMP(*fTarget)
+	goSNMP.MaxOids = *fMaxOids
+	managed := snmp.NewManaged(goSNMP)
+	managed.SourceAddress = options.SourceAddress
+	managed.Credentials = credentials
+	err = managed.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SNMP server: %v", err)
+	}
+
+	capabilities, err := snmp.Probe(ctx, managed)
+	if err != nil {
+		managed.Close()
+		return nil, fmt.Errorf("failed to probe the SNMP server: %v", err)
+	}
+	slog.Info("Probed the SNMP server", "target", *fTarget, "capabilities", capabilities)
+	managed.DisableBulk = !capabilities.GetBulk
+	return managed, nil
+}
+
+// trapNotifications returns the --trap-notification notifications, keyed by
+// numeric OID. Each is named by its symbolic name without the module, or by
+// its OID if it was given as one.
+func trapNotifications() (map[string]string, error) {
+	mibs, err := config.NewMIBs(fMIBFiles...)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, notification := range fTrapNotifications {
+		oid, err := mibs.Resolve(notification)
+		if err != nil {
+			return nil, err
+		}
+		name := notification
+		if i := strings.Index(name, "::"); i >= 0 {
+			name = name[i+2:]
+		}
+		names[oid] = name
+	}
+	return names, nil
+}
+
+// receiveTraps starts receiving notifications from the switch on
+// --trap-listen-address, sent with the same credentials it is polled with, and
+// archiving them every --write-interval with schedule. It returns a function
+// that stops receiving and archives any events not yet written.
+func receiveTraps(m *metrics.Metrics, schedule func(*scheduler.Scheduler)) (func(), error) {
+	names, err := trapNotifications()
+	if err != nil {
+		return nil, fmt.Errorf("invalid --trap-notification: %v", err)
+	}
+	credentials, err := snmpCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the SNMP credentials: %v", err)
+	}
+
+	events := metrics.NewEvents(m, names)
+	receiver, err := snmp.NewTrapReceiver(*fTrapListenAddress, credentials, events.Handle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for SNMP notifications: %v", err)
+	}
+	slog.Info("Receiving SNMP notifications", "target", *fTarget, "address", receiver.Addr())
+
+	writeInterval := time.Duration(*fWriteInterval) * time.Second
+	schedule(scheduler.New("write-events", writeInterval, systemClock, func(time.Time) {
+		events.Write(*fWriteInterval)
+	}))
+	return func() {
+		receiver.Close()
+		events.Flush(*fWriteInterval)
+	}, nil
+}
+
+// record walks the subtrees of the switch read by the metrics configuration,
+// along with the system group and any --record-oid subtrees, and writes them
+// to --record-file in snmprec format for replaying offline.
+func record(ctx context.Context) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not create new metrics configuration: %v", err)
+	}
+
+	managed, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer managed.Close()
+
+	out := os.Stdout
+	if *fRecordFile != "" {
+		out, err = os.Create(*fRecordFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	roots := append([]string{systemOid}, metrics.Subtrees(config)...)
+	roots = append(roots, fRecordOids...)
+	n, err := snmp.Record(ctx, managed, out, roots)
+	if err != nil {
+		return err
+	}
+	slog.Info("Recorded a fixture", "target", *fTarget, "variables", n)
+	return nil
+}
+
 func main() {
-	flag.Parse()
+	command, args, err := parseCommandLine(os.Args[1:])
+	if err == nil {
+		if err = logging.Setup(os.Stderr, *fLogLevel); err != nil {
+			err = fmt.Errorf("invalid --log-level: %v", err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "disco: %v\nRun 'disco --help' for usage.\n", err)
+		os.Exit(2)
+	}
+	switch command {
+	case "version":
+		fmt.Println(version())
+	case "validate-config":
+		os.Exit(validateConfig())
+	case "record":
+		rtx.Must(record(mainCtx), "Failed to record a fixture")
+	case "discover":
+		rtx.Must(discover(mainCtx, os.Stdout), "Failed to discover the interfaces of the switch")
+	case "get":
+		rtx.Must(get(mainCtx, os.Stdout, args), "Failed to get OIDs from the switch")
+	case "walk":
+		rtx.Must(walk(mainCtx, os.Stdout, args), "Failed to walk OIDs on the switch")
+	default:
+		rtx.Must(run(mainCtx), "Failed to collect metrics")
+	}
+}
+
+// commandUsage describes each command, for --help.
+var commandUsage = []struct{ command, description string }{
+	{"run", "Collect metrics from --target until stopped. The default."},
+	{"discover", "Print which interfaces of --target would be collected for, and why."},
+	{"get OID...", "Print the variables OID of --target, read with an SNMP GET."},
+	{"walk OID...", "Print the variables in the subtrees OID of --target."},
+	{"record", "Record the variables read by --metrics from --target as a fixture."},
+	{"validate-config", "Check the --metrics configuration and print any problems."},
+	{"version", "Print the version of disco."},
+}
+
+// usage prints the usage of disco, for --help.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: disco [command] [flags] [arguments]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, c := range commandUsage {
+		fmt.Fprintf(tw, "  %v\t%v\n", c.command, c.description)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "\nOIDs may be numeric or symbolic names from the standard MIBs and --mib-file.\n")
+	fmt.Fprintf(out, "Every flag can also be set with an environment variable named after it, such\n")
+	fmt.Fprintf(out, "as %v for --poll-interval. Flags on the command line take precedence.\n\n", envName("poll-interval"))
+	flag.PrintDefaults()
+}
+
+// parseCommandLine parses the command line args, with flags that weren't given
+// taken from the environment, and returns the command to run, one of those of
+// commandUsage, and its arguments. The command is the first argument that is
+// not a flag, and flags may come before or after it and its arguments. It
+// returns an error if the command is unknown, its arguments are wrong or a
+// flag it requires is missing.
+func parseCommandLine(args []string) (string, []string, error) {
+	flag.Usage = usage
+	positional := []string{}
+	for {
+		flag.CommandLine.Parse(args)
+		if flag.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flag.Arg(0))
+		args = flag.Args()[1:]
+	}
+	command := "run"
+	if len(positional) > 0 {
+		command, positional = positional[0], positional[1:]
+	}
+	if *fVersion {
+		return "version", nil, nil
+	}
+	if err := flagsFromEnv(flag.CommandLine); err != nil {
+		return "", nil, err
+	}
+
+	takesArgs := command == "get" || command == "walk"
+	if !takesArgs && len(positional) > 0 {
+		return "", nil, fmt.Errorf("unexpected arguments: %v", strings.Join(positional, " "))
+	}
+	if takesArgs && len(positional) == 0 {
+		return "", nil, fmt.Errorf("%v needs at least one OID", command)
+	}
+
+	switch command {
+	case "run", "record":
+		if *fTarget == "" && !(command == "run" && *fPrintConfig) {
+			return "", nil, fmt.Errorf("--target is required")
+		}
+		fallthrough
+	case "validate-config":
+		if *fMetricsFile == "" {
+			return "", nil, fmt.Errorf("--metrics is required")
+		}
+	case "discover", "get", "walk":
+		if *fTarget == "" {
+			return "", nil, fmt.Errorf("--target is required")
+		}
+	case "version":
+	default:
+		return "", nil, fmt.Errorf("unknown command %q", command)
+	}
+	return command, positional, nil
+}
+
+// envName returns the name of the environment variable for the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
 
-	if len(community) <= 0 {
-		log.Fatalf("Environment variable not set: DISCO_COMMUNITY")
+// flagsFromEnv sets each flag of fs that wasn't set on the command line from
+// its environment variable, if that is set. Only the variables named after a
+// flag are read, so other DISCO_ variables, such as DISCO_COMMUNITY, are
+// ignored, as are the variables of the flags in notFromEnv.
+func flagsFromEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if notFromEnv[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("invalid value %q for %v: %v", value, envName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// listenAddress returns the address to serve on: --listen-address, unless only
+// --prometheusx.listen-address, which earlier versions used, was set.
+func listenAddress() string {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["prometheusx.listen-address"] && !set["listen-address"] {
+		slog.Warn("--prometheusx.listen-address is deprecated, use --listen-address instead")
+		return *prometheusx.ListenAddress
+	}
+	return *fListenAddress
+}
+
+// version returns the build information printed by --version.
+func version() string {
+	v := fmt.Sprintf("disco %v, built with %v", prometheusx.GitShortCommit, runtime.Version())
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v += fmt.Sprintf(" from %v@%v", info.Main.Path, info.Main.Version)
 	}
+	return v
+}
 
-	hostname, err := os.Hostname()
-	rtx.Must(err, "Failed to determine the hostname of the system")
+// serveMux returns the ServeMux of the metrics server, to add endpoints to.
+func serveMux(srv *http.Server) (*http.ServeMux, error) {
+	mux, ok := srv.Handler.(*http.ServeMux)
+	if !ok {
+		return nil, fmt.Errorf("cannot add endpoints to the metrics server's %T", srv.Handler)
+	}
+	return mux, nil
+}
 
-	goSNMP := &gosnmp.GoSNMP{
-		Target:    *fTarget,
-		Port:      uint16(161),
-		Community: community,
-		Version:   gosnmp.Version2c,
-		Timeout:   time.Duration(2) * time.Second,
-		Retries:   1,
+// serveHealth adds the /healthz endpoint, serving the liveness checks, and the
+// /readyz endpoint, serving the readiness checks, to the metrics server.
+func serveHealth(srv *http.Server, liveness, readiness *health.Checks) error {
+	mux, err := serveMux(srv)
+	if err != nil {
+		return err
 	}
-	err = goSNMP.Connect()
-	rtx.Must(err, "Failed to connect to the SNMP server")
+	mux.Handle("/healthz", liveness)
+	mux.Handle("/readyz", readiness)
+	return nil
+}
 
-	config, err := config.New(*fMetricsFile)
-	rtx.Must(err, "Could not create new metrics configuration")
-	client := snmp.Client(goSNMP)
-	metrics := metrics.New(client, config, *fTarget, hostname)
+// run loads the metrics configuration, connects to the switch and collects
+// metrics from it until ctx is done.
+func run(ctx context.Context) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not create new metrics configuration: %v", err)
+	}
 
-	// Start scraping on a clean 10s boundary within a minute.
-	for time.Now().Second()%10 != 0 {
-		time.Sleep(1 * time.Second)
+	if *fPrintConfig {
+		return config.Print(os.Stdout)
+	}
+	if *fWriteInterval == 0 {
+		return fmt.Errorf("--write-interval must be at least one second")
 	}
 
-	promSrv := prometheusx.MustServeMetrics()
+	hostname, err := machineHostname()
+	if err != nil {
+		return fmt.Errorf("failed to determine the hostname of the system: %v", err)
+	}
 
+	// The health checks are served from the start, so that the process is
+	// reported as not ready while it discovers the interfaces of the switch.
+	liveness, readiness := &health.Checks{}, &health.Checks{}
+	readiness.Add("discovery", func() error {
+		return fmt.Errorf("the interfaces of %v are being discovered", *fTarget)
+	})
+	readiness.Add("archive", func() error { return archive.CheckWritable(".") })
+	promSrv := prometheusx.MustStartPrometheus(listenAddress())
+	defer promSrv.Close()
+	if err := serveHealth(promSrv, liveness, readiness); err != nil {
+		return err
+	}
+
+	managed, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer managed.Close()
+
+	client := snmp.NewBatched(managed, *fMaxOids)
+	metrics := metrics.New(ctx, client, config, *fTarget, hostname, systemClock)
+	readiness.Add("discovery", metrics.Discovered)
+	readiness.Add("poll", func() error {
+		return metrics.Collected(time.Duration(*fReadyPolls) * *fPollInterval)
+	})
+	if err := serveAdmin(promSrv, metrics, client, *fAdminActions); err != nil {
+		return err
+	}
+
+	// run returns only once everything it started has stopped.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
 	go func() {
-		<-mainCtx.Done()
-		goSNMP.Conn.Close()
-		promSrv.Close()
+		defer wg.Done()
+		watchConfig(ctx, metrics, *fMetricsCheck)
 	}()
 
-	cronWriteMetrics := gocron.NewScheduler(time.UTC)
-	cronWriteMetrics.Every(*fWriteInterval).Seconds().Do(metrics.Write, *fWriteInterval)
-	cronWriteMetrics.StartAsync()
+	schedule := func(s *scheduler.Scheduler) {
+		liveness.Add(s.Name(), s.Check)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(ctx)
+		}()
+	}
+
+	if *fTrapListenAddress != "" {
+		stop, err := receiveTraps(metrics, schedule)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	writeInterval := time.Duration(*fWriteInterval) * time.Second
+	schedule(scheduler.New("write", writeInterval, systemClock, func(time.Time) {
+		metrics.Write(*fWriteInterval)
+	}))
+
+	schedule(scheduler.New("collect", *fPollInterval, systemClock, func(tick time.Time) {
+		scrapeCtx, cancel := context.WithTimeout(ctx, *fPollInterval)
+		defer cancel()
+		metrics.CollectDue(scrapeCtx, client, tick)
+	}))
 
-	cronCollectMetrics := gocron.NewScheduler(time.UTC)
-	cronCollectMetrics.Every(10).Seconds().StartImmediately().Do(metrics.Collect, client, config)
-	cronCollectMetrics.StartBlocking()
+	<-ctx.Done()
+	// Once collection has stopped, the samples of the interval in progress
+	// are written, so that they aren't lost on a restart.
+	wg.Wait()
+	metrics.Flush(*fWriteInterval)
+	return nil
 }
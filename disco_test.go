@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/nkinkade/disco-go/health"
+	"github.com/nkinkade/disco-go/internal/snmpsim"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soniah/gosnmp"
+)
+
+const testMetrics = `
+- name: ifHCInOctets
+  description: Ingress octets.
+  oidStub: .1.3.6.1.2.1.31.1.1.1.6
+  mlabUplinkName: switch.octets.uplink.rx
+  mlabMachineName: switch.octets.local.rx
+`
+
+// counterValue returns the value of the counter with the given name and
+// interface label, and whether it was found.
+func counterValue(name string, iface string) (float64, bool) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return 0, false
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "interface" && label.GetValue() == iface {
+					return m.GetCounter().GetValue(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// setFlags sets the named flags, and the community, for the rest of the test,
+// restoring their previous values when it ends.
+func setFlags(t *testing.T, snmpCommunity string, values map[string]string) {
+	t.Helper()
+	prevCommunity := community
+	t.Cleanup(func() { community = prevCommunity })
+	community = snmpCommunity
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("There is no flag %v", name)
+		}
+		// A StringArray appends to itself when set, so it is restored by
+		// copying its previous elements back.
+		if sa, ok := f.Value.(*flagx.StringArray); ok {
+			prev := append(flagx.StringArray{}, *sa...)
+			t.Cleanup(func() { *sa = prev })
+		} else {
+			prev := f.Value.String()
+			t.Cleanup(func() { f.Value.Set(prev) })
+		}
+		if err := flag.Set(name, value); err != nil {
+			t.Fatalf("Failed to set flag %v: %v", name, err)
+		}
+	}
+}
+
+// Test_Run runs the full collection loop against a simulated switch, checking
+// that counters are scraped and exposed to Prometheus.
+func Test_Run(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping the integration test in short mode")
+	}
+
+	agent, err := snmpsim.New("snmp-community")
+	if err != nil {
+		t.Fatalf("Failed to start the simulated switch: %v", err)
+	}
+	defer agent.Close()
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.18.524", gosnmp.OctetString, "mlab2")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.18.568", gosnmp.OctetString, "uplink-10g")
+	agent.Set(".1.3.6.1.2.1.2.2.1.2.524", gosnmp.OctetString, "xe-0/0/12")
+	agent.Set(".1.3.6.1.2.1.2.2.1.2.568", gosnmp.OctetString, "xe-0/0/45")
+	agent.SetCounter(".1.3.6.1.2.1.31.1.1.1.6.524", gosnmp.Counter64, 1000, 250)
+	agent.SetCounter(".1.3.6.1.2.1.31.1.1.1.6.568", gosnmp.Counter64, 5000, 700)
+
+	dir, err := ioutil.TempDir("", "disco-run")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	metricsFile := path.Join(dir, "metrics.yaml")
+	err = ioutil.WriteFile(metricsFile, []byte(testMetrics), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the metrics file: %v", err)
+	}
+
+	// Archives are written relative to the working directory, so run writes
+	// them to a directory of the test's own rather than the source tree.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get the working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change the working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// The counters are checked in a registry of this test's own, so that they
+	// don't start at the values of an earlier run.
+	prevRegisterer, prevGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer, prometheus.DefaultGatherer = prevRegisterer, prevGatherer
+	})
+	registry := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer, prometheus.DefaultGatherer = registry, registry
+	prevHostname := osHostname
+	t.Cleanup(func() { osHostname = prevHostname })
+	osHostname = func() (string, error) {
+		return "mlab2-abc0t.mlab-sandbox.measurement-lab.org", nil
+	}
+	setFlags(t, "snmp-community", map[string]string{
+		"metrics":                 metricsFile,
+		"target":                  "127.0.0.1",
+		"snmp-port":               fmt.Sprint(agent.Port()),
+		"snmp-timeout":            "500ms",
+		"listen-address":          "127.0.0.1:0",
+		"print-config":            "false",
+		"metrics-check-interval":  "0",
+		"snmpv3-credentials-file": "",
+		"community-file":          "",
+		"snmp-source-address":     "",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		errc <- run(ctx)
+	}()
+
+	// The counters are read once on each scrape, so after two scrapes each
+	// has increased by its step. The first scrape may be up to 10s away.
+	deadline := time.Now().Add(30 * time.Second)
+	expected := map[string]float64{"xe-0/0/12": 250, "xe-0/0/45": 700}
+	for iface, want := range expected {
+		for {
+			got, ok := counterValue("ifHCInOctets", iface)
+			if ok && got == want {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for ifHCInOctets{interface=%q} to be %v, last got: %v", iface, want, got)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	cancel()
+	select {
+	case err = <-errc:
+		if err != nil {
+			t.Errorf("run() returned an unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() did not return after its context was cancelled")
+	}
+
+	// The samples of the interval in progress are written on shutdown.
+	archives, err := filepath.Glob("*/*/*/*/*-switch.json")
+	if err != nil || len(archives) == 0 {
+		t.Errorf("Expected run() to write an archive on shutdown, got %v, %v", archives, err)
+	}
+}
+
+// Test_Record records a fixture from a simulated switch and checks that
+// replaying it serves the subtrees read by the metrics configuration.
+func Test_Record(t *testing.T) {
+	agent, err := snmpsim.New("snmp-community")
+	if err != nil {
+		t.Fatalf("Failed to start the simulated switch: %v", err)
+	}
+	defer agent.Close()
+	agent.Set(".1.3.6.1.2.1.1.1.0", gosnmp.OctetString, "Juniper Networks, Inc. qfx5100-48s-6q")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.18.524", gosnmp.OctetString, "mlab2")
+	agent.Set(".1.3.6.1.2.1.2.2.1.2.524", gosnmp.OctetString, "xe-0/0/12")
+	agent.Set(".1.3.6.1.2.1.31.1.1.1.6.524", gosnmp.Counter64, uint64(1000))
+	agent.Set(".1.3.6.1.4.1.2636.3.1.13.1.7.9.1.0.0", gosnmp.Gauge32, uint(42))
+	agent.Set(".1.3.6.1.2.1.4.1.0", gosnmp.Integer, 2)
+
+	dir, err := ioutil.TempDir("", "disco-record")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	metricsFile := path.Join(dir, "metrics.yaml")
+	err = ioutil.WriteFile(metricsFile, []byte(testMetrics), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the metrics file: %v", err)
+	}
+	recordFile := path.Join(dir, "switch.snmprec")
+
+	setFlags(t, "snmp-community", map[string]string{
+		"metrics":                 metricsFile,
+		"target":                  "127.0.0.1",
+		"snmp-port":               fmt.Sprint(agent.Port()),
+		"snmp-timeout":            "500ms",
+		"snmpv3-credentials-file": "",
+		"community-file":          "",
+		"snmp-source-address":     "",
+		"record-file":             recordFile,
+		"record-oid":              ".1.3.6.1.4.1.2636.3.1.13",
+	})
+
+	err = record(context.Background())
+	if err != nil {
+		t.Fatalf("record() returned an unexpected error: %v", err)
+	}
+
+	replay, err := snmp.LoadReplay(recordFile)
+	if err != nil {
+		t.Fatalf("Failed to load the recorded fixture: %v", err)
+	}
+	expected := map[string]interface{}{
+		".1.3.6.1.2.1.1.1.0":                   "Juniper Networks, Inc. qfx5100-48s-6q",
+		".1.3.6.1.2.1.31.1.1.1.18.524":         "mlab2",
+		".1.3.6.1.2.1.2.2.1.2.524":             "xe-0/0/12",
+		".1.3.6.1.2.1.31.1.1.1.6.524":          uint64(1000),
+		".1.3.6.1.4.1.2636.3.1.13.1.7.9.1.0.0": uint(42),
+		".1.3.6.1.2.1.4.1.0":                   nil,
+	}
+	for oid, want := range expected {
+		packet, _ := replay.Get(context.Background(), []string{oid})
+		got := packet.Variables[0].Value
+		if b, ok := got.([]byte); ok {
+			got = string(b)
+		}
+		if got != want {
+			t.Errorf("Recorded value of %v = %#v, want %#v", oid, got, want)
+		}
+	}
+}
+
+func Test_TrapNotifications(t *testing.T) {
+	fTrapNotifications = []string{"SNMPv2-MIB::coldStart", ".1.3.6.1.2.1.47.2.0.1"}
+	defer func() { fTrapNotifications = nil }()
+
+	names, err := trapNotifications()
+	if err != nil {
+		t.Fatalf("trapNotifications() returned an unexpected error: %v", err)
+	}
+	want := map[string]string{
+		".1.3.6.1.6.3.1.1.5.1":  "coldStart",
+		".1.3.6.1.2.1.47.2.0.1": ".1.3.6.1.2.1.47.2.0.1",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("trapNotifications() = %v, want %v", names, want)
+	}
+
+	fTrapNotifications = []string{"NO-SUCH-MIB::coldStart"}
+	if _, err := trapNotifications(); err == nil {
+		t.Errorf("trapNotifications() of an unknown name didn't return an error")
+	}
+}
+
+func Test_ServeHealth(t *testing.T) {
+	liveness, readiness := &health.Checks{}, &health.Checks{}
+	readiness.Add("discovery", func() error { return errors.New("in progress") })
+	srv := &http.Server{Handler: http.NewServeMux()}
+	if err := serveHealth(srv, liveness, readiness); err != nil {
+		t.Fatalf("serveHealth() error = %v", err)
+	}
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %v status = %v, want %v", path, rec.Code, want)
+		}
+	}
+
+	srv = &http.Server{Handler: http.NotFoundHandler()}
+	if err := serveHealth(srv, liveness, readiness); err == nil {
+		t.Errorf("Expected serveHealth() to fail without a ServeMux")
+	}
+}
+
+func Test_FlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("disco", flag.ContinueOnError)
+	interval := fs.Duration("poll-interval", 10*time.Second, "")
+	target := fs.String("target", "", "")
+	listen := fs.String("prometheusx.listen-address", ":9990", "")
+	var mibs flagx.StringArray
+	fs.Var(&mibs, "mib-file", "")
+	version := fs.Bool("version", false, "")
+
+	os.Setenv("DISCO_POLL_INTERVAL", "20s")
+	os.Setenv("DISCO_TARGET", "s1-abc0t.measurement-lab.org")
+	os.Setenv("DISCO_PROMETHEUSX_LISTEN_ADDRESS", ":9991")
+	os.Setenv("DISCO_MIB_FILE", "a.mib,b.mib")
+	// Variables that aren't flag settings are ignored.
+	os.Setenv("DISCO_VERSION", "1.2.3")
+	os.Setenv("DISCO_UNRELATED", "value")
+	defer func() {
+		for _, name := range []string{"DISCO_POLL_INTERVAL", "DISCO_TARGET", "DISCO_PROMETHEUSX_LISTEN_ADDRESS", "DISCO_MIB_FILE", "DISCO_VERSION", "DISCO_UNRELATED"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	// Flags on the command line take precedence.
+	if err := fs.Parse([]string{"--target", "s1-xyz0t.measurement-lab.org"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := flagsFromEnv(fs); err != nil {
+		t.Fatalf("flagsFromEnv() error = %v", err)
+	}
+	if *interval != 20*time.Second || *target != "s1-xyz0t.measurement-lab.org" || *listen != ":9991" {
+		t.Errorf("Flags = %v, %v, %v", *interval, *target, *listen)
+	}
+	if !reflect.DeepEqual([]string(mibs), []string{"a.mib", "b.mib"}) {
+		t.Errorf("--mib-file = %v, want a.mib and b.mib", mibs)
+	}
+	if *version {
+		t.Errorf("Expected DISCO_VERSION not to set --version")
+	}
+
+	os.Setenv("DISCO_POLL_INTERVAL", "often")
+	fs = flag.NewFlagSet("disco", flag.ContinueOnError)
+	fs.Duration("poll-interval", 10*time.Second, "")
+	if err := flagsFromEnv(fs); err == nil || !strings.Contains(err.Error(), "DISCO_POLL_INTERVAL") {
+		t.Errorf("Expected an error naming DISCO_POLL_INTERVAL, got: %v", err)
+	}
+}
+
+func Test_ParseCommandLine(t *testing.T) {
+	defer func(target, metrics string, timeout time.Duration) {
+		flag.Set("target", target)
+		flag.Set("metrics", metrics)
+		flag.Set("snmp-timeout", timeout.String())
+	}(*fTarget, *fMetricsFile, *fSNMPTimeout)
+	flag.Set("target", "")
+	flag.Set("metrics", "metrics.yaml")
+
+	tests := []struct {
+		args    []string
+		command string
+		rest    []string
+		err     string
+	}{
+		{args: []string{}, err: "--target is required"},
+		{args: []string{"record"}, err: "--target is required"},
+		{args: []string{"validate-config"}, command: "validate-config"},
+		{args: []string{"--metrics", "metrics.yaml", "validate-config"}, command: "validate-config"},
+		{args: []string{"validate", "--metrics", "metrics.yaml"}, err: `unknown command "validate"`},
+		{args: []string{"record", "extra"}, err: "unexpected arguments: extra"},
+		{args: []string{"--target", "s1-abc0t.measurement-lab.org"}, command: "run"},
+		{args: []string{"run", "--target", "s1-abc0t.measurement-lab.org"}, command: "run"},
+		{args: []string{"--metrics", "", "record"}, err: "--metrics is required"},
+		{args: []string{"--version", "record"}, command: "version"},
+		{args: []string{"version"}, command: "version"},
+		{args: []string{"--target", "", "discover"}, err: "--target is required"},
+		{args: []string{"discover", "--target", "s1-abc0t.measurement-lab.org", "--metrics", ""}, command: "discover"},
+		{args: []string{"--target", "s1-abc0t.measurement-lab.org", "walk"}, err: "walk needs at least one OID"},
+		{
+			args:    []string{"get", "--target", "s1-abc0t.measurement-lab.org", "sysName.0", "--snmp-timeout", "5s", "ifDescr.524"},
+			command: "get",
+			rest:    []string{"sysName.0", "ifDescr.524"},
+		},
+	}
+	for _, tt := range tests {
+		command, rest, err := parseCommandLine(tt.args)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseCommandLine(%v) error = %v, want %v", tt.args, err, tt.err)
+			}
+		} else if err != nil || command != tt.command || strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+			t.Errorf("parseCommandLine(%v) = %q, %v, %v, want %q, %v", tt.args, command, rest, err, tt.command, tt.rest)
+		}
+		flag.Set("version", "false")
+	}
+}
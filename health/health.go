@@ -0,0 +1,76 @@
+// Package health serves named checks of the state of the process over HTTP,
+// with the result of each check as JSON, for liveness and readiness probes.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Check returns an error describing why the process is not healthy or ready,
+// or nil if it is.
+type Check func() error
+
+// Result is the JSON result of a set of checks. Status is "ok" if every
+// check passed, and "fail" otherwise.
+type Result struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// CheckResult is the result of a single check, with the error if it failed.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Checks is a set of named checks. It is an http.Handler that runs them all
+// and replies with their Result, with status 200 if they all passed and 503
+// otherwise. It is safe for concurrent use, so checks may be added while it
+// is being served, such as once the state they check exists.
+type Checks struct {
+	mutex  sync.Mutex
+	checks map[string]Check
+}
+
+// Add adds check as name, replacing any check already added as name.
+func (c *Checks) Add(name string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.checks == nil {
+		c.checks = make(map[string]Check)
+	}
+	c.checks[name] = check
+}
+
+// Run runs every check and returns their Result.
+func (c *Checks) Run() Result {
+	c.mutex.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mutex.Unlock()
+
+	result := Result{Status: "ok", Checks: make(map[string]CheckResult)}
+	for name, check := range checks {
+		if err := check(); err != nil {
+			result.Status = "fail"
+			result.Checks[name] = CheckResult{Status: "fail", Error: err.Error()}
+			continue
+		}
+		result.Checks[name] = CheckResult{Status: "ok"}
+	}
+	return result
+}
+
+// ServeHTTP replies with the Result of running the checks.
+func (c *Checks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result := c.Run()
+	w.Header().Set("Content-Type", "application/json")
+	if result.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
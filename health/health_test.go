@@ -0,0 +1,54 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_Checks(t *testing.T) {
+	c := &Checks{}
+
+	// With no checks, the process is healthy.
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Status with no checks = %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	c.Add("discovery", func() error { return errors.New("in progress") })
+	c.Add("poll", func() error { return nil })
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status with a failed check = %v, want %v", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	got := Result{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Could not decode the result: %v", err)
+	}
+	want := Result{
+		Status: "fail",
+		Checks: map[string]CheckResult{
+			"discovery": {Status: "fail", Error: "in progress"},
+			"poll":      {Status: "ok"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Result = %+v, want %+v", got, want)
+	}
+
+	// A check added again replaces the first.
+	c.Add("discovery", func() error { return nil })
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Status once every check passes = %v, want %v", rec.Code, http.StatusOK)
+	}
+}
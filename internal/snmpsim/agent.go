@@ -0,0 +1,370 @@
+// Package snmpsim implements a simulated SNMP agent for tests. It serves
+// SNMPv1, SNMPv2c and SNMPv3 GET, GETNEXT and GETBULK requests over UDP on the
+// loopback interface from a programmable view of objects, and can simulate
+// counters that wrap and reset, timeouts and malformed responses.
+package snmpsim
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Object is a single object in the view of an Agent.
+type Object struct {
+	Type  gosnmp.Asn1BER
+	Value interface{}
+	// Step is added to the value of a Counter32 or Counter64 object each time
+	// it is read. The value of a Counter32 wraps at 2^32.
+	Step uint64
+}
+
+// Agent is a simulated SNMP agent. Its view and faults may be changed while it
+// is serving requests.
+type Agent struct {
+	// Community is the SNMPv2c community the agent answers to. Requests with
+	// any other community are ignored, as real agents do.
+	Community string
+	// EngineID is the SNMPv3 authoritative engine ID of the agent.
+	EngineID string
+	conn     *net.UDPConn
+	start    time.Time
+	done     chan struct{}
+
+	mutex     sync.Mutex
+	objects   map[string]*Object
+	sorted    []string
+	users     map[string]User
+	drop      int
+	malformed int
+	noBulk    bool
+	requests  int
+}
+
+// New starts a new Agent listening on a random UDP port of the loopback
+// interface, answering SNMPv1 and SNMPv2c requests for community.
+func New(community string) (*Agent, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	a := &Agent{
+		Community: community,
+		EngineID:  "\x80\x00\x1f\x88\x04disco-snmpsim",
+		conn:      conn,
+		start:     time.Now(),
+		done:      make(chan struct{}),
+		objects:   make(map[string]*Object),
+		users:     make(map[string]User),
+	}
+	go a.serve()
+	return a, nil
+}
+
+// Addr returns the address the agent is listening on.
+func (a *Agent) Addr() *net.UDPAddr {
+	return a.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Port returns the UDP port the agent is listening on.
+func (a *Agent) Port() uint16 {
+	return uint16(a.Addr().Port)
+}
+
+// Close stops the agent.
+func (a *Agent) Close() error {
+	err := a.conn.Close()
+	<-a.done
+	return err
+}
+
+// Set sets the type and value of the object with the given OID, adding it to
+// the view if it isn't already there.
+func (a *Agent) Set(oid string, typ gosnmp.Asn1BER, value interface{}) {
+	a.SetObject(oid, Object{Type: typ, Value: value})
+}
+
+// SetCounter sets a Counter32 or Counter64 object that starts at start and
+// increases by step each time it is read.
+func (a *Agent) SetCounter(oid string, typ gosnmp.Asn1BER, start uint64, step uint64) {
+	a.SetObject(oid, Object{Type: typ, Value: start, Step: step})
+}
+
+// SetObject sets the object with the given OID, adding it to the view if it
+// isn't already there.
+func (a *Agent) SetObject(oid string, object Object) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	oid = normalize(oid)
+	if _, ok := a.objects[oid]; !ok {
+		a.sorted = nil
+	}
+	a.objects[oid] = &object
+}
+
+// Reset sets the value of a counter back to zero, as when the agent restarts.
+func (a *Agent) Reset(oid string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if object, ok := a.objects[normalize(oid)]; ok {
+		object.Value = uint64(0)
+	}
+}
+
+// Delete removes the object with the given OID from the view.
+func (a *Agent) Delete(oid string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.objects, normalize(oid))
+	a.sorted = nil
+}
+
+// Timeout makes the agent ignore the next n requests, so that they time out.
+func (a *Agent) Timeout(n int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.drop = n
+}
+
+// Malformed makes the agent answer the next n requests with responses that
+// can't be decoded.
+func (a *Agent) Malformed(n int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.malformed = n
+}
+
+// NoBulk makes the agent ignore GETBULK requests if ignore is true, as some
+// agents that don't implement them do. SNMPv1 requests never include GETBULK.
+func (a *Agent) NoBulk(ignore bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.noBulk = ignore
+}
+
+// Requests returns the number of requests the agent has received, including
+// those it ignored.
+func (a *Agent) Requests() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.requests
+}
+
+// serve answers requests until the agent is closed.
+func (a *Agent) serve() {
+	defer close(a.done)
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		resp := a.handle(append([]byte{}, buf[:n]...))
+		if resp != nil {
+			a.conn.WriteToUDP(resp, addr)
+		}
+	}
+}
+
+// handle returns the response to a request, or nil if there should be none.
+func (a *Agent) handle(req []byte) []byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.requests++
+	if a.drop > 0 {
+		a.drop--
+		return nil
+	}
+	if a.malformed > 0 {
+		a.malformed--
+		return []byte{0x30, 0x82, 0xff, 0xff, 0x02, 0x01}
+	}
+
+	if version(req) == gosnmp.Version3 {
+		return a.handleV3(req)
+	}
+	x := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	packet, err := x.SnmpDecodePacket(req)
+	if err != nil || packet.Community != a.Community {
+		return nil
+	}
+	switch {
+	case packet.Version != gosnmp.Version1 && packet.Version != gosnmp.Version2c:
+		return nil
+	case packet.PDUType == gosnmp.GetBulkRequest && (packet.Version == gosnmp.Version1 || a.noBulk):
+		return nil
+	}
+	resp := &gosnmp.SnmpPacket{
+		Version:   packet.Version,
+		Community: packet.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: packet.RequestID,
+		Variables: a.respond(packet),
+	}
+	if packet.Version == gosnmp.Version1 {
+		v1Error(resp, packet)
+	}
+	data, err := resp.MarshalMsg()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// respond returns the variables answering a request PDU.
+func (a *Agent) respond(packet *gosnmp.SnmpPacket) []gosnmp.SnmpPDU {
+	vars := []gosnmp.SnmpPDU{}
+	switch packet.PDUType {
+	case gosnmp.GetRequest:
+		for _, v := range packet.Variables {
+			vars = append(vars, a.get(v.Name))
+		}
+	case gosnmp.GetNextRequest:
+		for _, v := range packet.Variables {
+			vars = append(vars, a.next(v.Name))
+		}
+	case gosnmp.GetBulkRequest:
+		nonRepeaters := int(packet.NonRepeaters)
+		if nonRepeaters > len(packet.Variables) {
+			nonRepeaters = len(packet.Variables)
+		}
+		for _, v := range packet.Variables[:nonRepeaters] {
+			vars = append(vars, a.next(v.Name))
+		}
+		repeaters := []string{}
+		for _, v := range packet.Variables[nonRepeaters:] {
+			repeaters = append(repeaters, v.Name)
+		}
+		// gosnmp doesn't decode the max-repetitions of requests, so a default
+		// is used when it is missing.
+		maxRepetitions := int(packet.MaxRepetitions)
+		if maxRepetitions == 0 {
+			maxRepetitions = 10
+		}
+		for r := 0; r < maxRepetitions && len(repeaters) > 0; r++ {
+			for i, oid := range repeaters {
+				pdu := a.next(oid)
+				vars = append(vars, pdu)
+				repeaters[i] = pdu.Name
+			}
+		}
+	}
+	return vars
+}
+
+// v1Error replaces exception values in a response with the noSuchName
+// error-status used by SNMPv1, which has no exceptions. As with an SNMPv1
+// agent, the variables of the request are returned along with the error.
+func v1Error(resp *gosnmp.SnmpPacket, req *gosnmp.SnmpPacket) {
+	for i, v := range resp.Variables {
+		switch v.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+			resp.Error = gosnmp.NoSuchName
+			resp.ErrorIndex = uint8(i + 1)
+			resp.Variables = req.Variables
+			return
+		}
+	}
+}
+
+// get returns the variable for an OID, with a NoSuchObject value if it isn't
+// in the view.
+func (a *Agent) get(oid string) gosnmp.SnmpPDU {
+	oid = normalize(oid)
+	object, ok := a.objects[oid]
+	if !ok {
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.NoSuchObject}
+	}
+	return a.read(oid, object)
+}
+
+// next returns the variable for the first OID in the view after oid, with an
+// EndOfMibView value if there is none.
+func (a *Agent) next(oid string) gosnmp.SnmpPDU {
+	oid = normalize(oid)
+	if a.sorted == nil {
+		for o := range a.objects {
+			a.sorted = append(a.sorted, o)
+		}
+		sort.Slice(a.sorted, func(i, j int) bool { return less(a.sorted[i], a.sorted[j]) })
+	}
+	i := sort.Search(len(a.sorted), func(i int) bool { return less(oid, a.sorted[i]) })
+	if i == len(a.sorted) {
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+	}
+	return a.read(a.sorted[i], a.objects[a.sorted[i]])
+}
+
+// read returns the variable for an object, advancing it if it is a counter.
+func (a *Agent) read(oid string, object *Object) gosnmp.SnmpPDU {
+	pdu := gosnmp.SnmpPDU{Name: oid, Type: object.Type, Value: object.Value}
+	switch object.Type {
+	case gosnmp.Counter32, gosnmp.Counter64:
+		value := toUint64(object.Value)
+		if object.Type == gosnmp.Counter32 {
+			value = uint64(uint32(value))
+			pdu.Value = uint32(value)
+		} else {
+			pdu.Value = value
+		}
+		object.Value = value + object.Step
+	}
+	return pdu
+}
+
+// toUint64 converts an unsigned or int value to a uint64.
+func toUint64(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	case int:
+		return uint64(v)
+	}
+	return 0
+}
+
+// version returns the SNMP version of a message, without decoding the rest of
+// it.
+func version(msg []byte) gosnmp.SnmpVersion {
+	// The message is a sequence whose first element is the version integer.
+	i := 2
+	if len(msg) > 1 && msg[1]&0x80 != 0 {
+		i += int(msg[1] & 0x7f)
+	}
+	if len(msg) < i+3 || msg[i] != byte(gosnmp.Integer) || msg[i+1] != 1 {
+		return 0xff
+	}
+	return gosnmp.SnmpVersion(msg[i+2])
+}
+
+// normalize returns an OID with a leading dot.
+func normalize(oid string) string {
+	if strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
+}
+
+// less reports whether OID a sorts before OID b.
+func less(a string, b string) bool {
+	pa := strings.Split(strings.TrimPrefix(a, "."), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, _ := strconv.Atoi(pa[i])
+		nb, _ := strconv.Atoi(pb[i])
+		if na != nb {
+			return na < nb
+		}
+	}
+	return len(pa) < len(pb)
+}
@@ -0,0 +1,329 @@
+package snmpsim
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/soniah/gosnmp"
+)
+
+const (
+	sysDescrOID  = ".1.3.6.1.2.1.1.1.0"
+	ifDescrOid1  = ".1.3.6.1.2.1.2.2.1.2.1"
+	ifDescrOid2  = ".1.3.6.1.2.1.2.2.1.2.2"
+	ifDescrOid10 = ".1.3.6.1.2.1.2.2.1.2.10"
+	ifInOctets1  = ".1.3.6.1.2.1.2.2.1.10.1"
+)
+
+func newAgent(t *testing.T) *Agent {
+	a, err := New("snmp-community")
+	if err != nil {
+		t.Fatalf("Failed to start the agent: %v", err)
+	}
+	a.Set(sysDescrOID, gosnmp.OctetString, "Simulated switch")
+	a.Set(ifDescrOid10, gosnmp.OctetString, "xe-0/0/10")
+	a.Set(ifDescrOid2, gosnmp.OctetString, "xe-0/0/2")
+	a.Set(ifDescrOid1, gosnmp.OctetString, "xe-0/0/1")
+	return a
+}
+
+func newClient(t *testing.T, a *Agent, configure func(g *gosnmp.GoSNMP)) *snmp.RealSNMP {
+	g := &gosnmp.GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      a.Port(),
+		Community: "snmp-community",
+		Version:   gosnmp.Version2c,
+		Timeout:   200 * time.Millisecond,
+		Retries:   0,
+	}
+	if configure != nil {
+		configure(g)
+	}
+	err := g.Connect()
+	if err != nil {
+		t.Fatalf("Failed to connect to the agent: %v", err)
+	}
+	return snmp.Client(g)
+}
+
+func Test_AgentGet(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, nil)
+	defer c.Close()
+
+	result, err := c.Get(context.Background(), []string{sysDescrOID, ".1.3.6.1.2.1.1.99.0"})
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	if string(result.Variables[0].Value.([]byte)) != "Simulated switch" {
+		t.Errorf("Unexpected value for %v: %v", sysDescrOID, result.Variables[0].Value)
+	}
+	if result.Variables[1].Type != gosnmp.NoSuchObject {
+		t.Errorf("Expected NoSuchObject for a missing OID, but got: %v", result.Variables[1].Type)
+	}
+}
+
+func Test_AgentBulkWalk(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, nil)
+	defer c.Close()
+
+	pdus, err := c.BulkWalkAll(context.Background(), ".1.3.6.1.2.1.2.2.1.2")
+	if err != nil {
+		t.Fatalf("BulkWalkAll() returned an unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, pdu := range pdus {
+		names = append(names, pdu.Name)
+	}
+	// OIDs are ordered numerically, not lexically.
+	expected := []string{ifDescrOid1, ifDescrOid2, ifDescrOid10}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected walk.\nGot: %v\nExpected: %v", names, expected)
+	}
+}
+
+func Test_AgentV1(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, func(g *gosnmp.GoSNMP) { g.Version = gosnmp.Version1 })
+	defer c.Close()
+
+	// SNMPv1 has no exceptions, so a missing OID fails the whole request.
+	result, err := c.Get(context.Background(), []string{sysDescrOID, ".1.3.6.1.2.1.1.99.0"})
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	if result.Error != gosnmp.NoSuchName || result.ErrorIndex != 2 {
+		t.Errorf("Expected noSuchName at index 2, but got: %v at %v", result.Error, result.ErrorIndex)
+	}
+
+	// SNMPv1 has no GetBulk, so walks use GetNext.
+	pdus, err := c.BulkWalkAll(context.Background(), ".1.3.6.1.2.1.2.2.1.2")
+	if err != nil || len(pdus) != 3 {
+		t.Errorf("Expected a walk of 3 OIDs, but got: %v, %v", pdus, err)
+	}
+	_, err = c.GetBulk(context.Background(), []string{".1.3.6.1.2.1.2.2.1.2"}, 0, 10)
+	if err == nil {
+		t.Error("Expected GetBulk to fail for SNMPv1")
+	}
+}
+
+func Test_Probe(t *testing.T) {
+	tests := []struct {
+		name      string
+		noBulk    bool
+		configure func(g *gosnmp.GoSNMP)
+		want      snmp.Capabilities
+	}{
+		{
+			name: "v2c",
+			want: snmp.Capabilities{Get: true, GetNext: true, GetBulk: true},
+		},
+		{
+			name:   "v2c-without-getbulk",
+			noBulk: true,
+			want:   snmp.Capabilities{Get: true, GetNext: true},
+		},
+		{
+			name:      "v1",
+			configure: func(g *gosnmp.GoSNMP) { g.Version = gosnmp.Version1 },
+			want:      snmp.Capabilities{Get: true, GetNext: true},
+		},
+	}
+	for _, tt := range tests {
+		a := newAgent(t)
+		a.Set(".1.3.6.1.2.1.1.2.0", gosnmp.ObjectIdentifier, ".1.3.6.1.4.1.2636.1.1.1.2.82")
+		a.NoBulk(tt.noBulk)
+		c := newClient(t, a, tt.configure)
+
+		got, err := snmp.Probe(context.Background(), c)
+		if err != nil || got != tt.want {
+			t.Errorf("%v: Probe() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+		c.Close()
+		a.Close()
+	}
+
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, func(g *gosnmp.GoSNMP) { g.Community = "wrong" })
+	defer c.Close()
+	if _, err := snmp.Probe(context.Background(), c); err == nil {
+		t.Error("Expected Probe() to fail for an agent that doesn't answer")
+	}
+}
+
+func Test_AgentCounters(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, nil)
+	defer c.Close()
+
+	a.SetCounter(ifInOctets1, gosnmp.Counter32, 1<<32-100, 60)
+	expected := []uint{1<<32 - 100, 1<<32 - 40, 20, 0, 60}
+	for i, want := range expected {
+		if i == 3 {
+			a.Reset(ifInOctets1)
+		}
+		result, err := c.Get(context.Background(), []string{ifInOctets1})
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if got := result.Variables[0].Value; got != want {
+			t.Errorf("Read %v: expected %v, but got: %v", i, want, got)
+		}
+	}
+}
+
+func Test_AgentFaults(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, nil)
+	defer c.Close()
+
+	a.Timeout(1)
+	_, err := c.Get(context.Background(), []string{sysDescrOID})
+	if err == nil {
+		t.Error("Expected a timeout, but didn't get an error")
+	}
+
+	a.Malformed(1)
+	_, err = c.Get(context.Background(), []string{sysDescrOID})
+	if err == nil {
+		t.Error("Expected an error for a malformed response, but didn't get one")
+	}
+
+	_, err = c.Get(context.Background(), []string{sysDescrOID})
+	if err != nil {
+		t.Errorf("Expected the agent to recover from faults, but got: %v", err)
+	}
+	if a.Requests() != 3 {
+		t.Errorf("Expected 3 requests, but got: %v", a.Requests())
+	}
+}
+
+func Test_AgentCancel(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, func(g *gosnmp.GoSNMP) { g.Timeout = 10 * time.Second })
+	defer c.Close()
+
+	// A cancelled request is abandoned while waiting for a response, long
+	// before its timeout.
+	a.Timeout(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := c.Get(ctx, []string{sysDescrOID})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the request to be abandoned promptly, but it took %v", elapsed)
+	}
+
+	a.Timeout(1)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = c.BulkWalkAll(ctx, ".1.3.6.1.2.1.2.2.1.2")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, but got: %v", err)
+	}
+
+	// The client is still usable afterwards.
+	_, err = c.Get(context.Background(), []string{sysDescrOID})
+	if err != nil {
+		t.Errorf("Expected the request after a cancellation to succeed, but got: %v", err)
+	}
+}
+
+func Test_AgentWrongCommunity(t *testing.T) {
+	a := newAgent(t)
+	defer a.Close()
+	c := newClient(t, a, func(g *gosnmp.GoSNMP) { g.Community = "wrong" })
+	defer c.Close()
+
+	_, err := c.Get(context.Background(), []string{sysDescrOID})
+	if err == nil {
+		t.Error("Expected a request with the wrong community to time out, but it didn't")
+	}
+}
+
+func Test_AgentV3(t *testing.T) {
+	users := []User{
+		{Name: "noauth"},
+		{Name: "authnopriv", AuthProtocol: gosnmp.MD5, AuthPassphrase: "auth-passphrase"},
+		{Name: "authpriv", AuthProtocol: gosnmp.SHA, AuthPassphrase: "auth-passphrase", PrivProtocol: gosnmp.AES, PrivPassphrase: "priv-passphrase"},
+		{Name: "authpriv-des", AuthProtocol: gosnmp.SHA256, AuthPassphrase: "auth-passphrase", PrivProtocol: gosnmp.DES, PrivPassphrase: "priv-passphrase"},
+	}
+	a := newAgent(t)
+	defer a.Close()
+	for _, u := range users {
+		a.AddUser(u)
+	}
+
+	tests := []struct {
+		user    User
+		flags   gosnmp.SnmpV3MsgFlags
+		wantErr bool
+	}{
+		{user: users[0], flags: gosnmp.NoAuthNoPriv},
+		{user: users[1], flags: gosnmp.AuthNoPriv},
+		{user: users[2], flags: gosnmp.AuthPriv},
+		{user: users[3], flags: gosnmp.AuthPriv},
+		{
+			user:    User{Name: "authpriv", AuthProtocol: gosnmp.SHA, AuthPassphrase: "wrong-passphrase", PrivProtocol: gosnmp.AES, PrivPassphrase: "priv-passphrase"},
+			flags:   gosnmp.AuthPriv,
+			wantErr: true,
+		},
+		{
+			user:    User{Name: "unknown", AuthProtocol: gosnmp.NoAuth, PrivProtocol: gosnmp.NoPriv},
+			flags:   gosnmp.NoAuthNoPriv,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		c := newClient(t, a, func(g *gosnmp.GoSNMP) {
+			g.Version = gosnmp.Version3
+			g.SecurityModel = gosnmp.UserSecurityModel
+			g.MsgFlags = tt.flags
+			g.SecurityParameters = &gosnmp.UsmSecurityParameters{
+				UserName:                 tt.user.Name,
+				AuthenticationProtocol:   orNoAuth(tt.user.AuthProtocol),
+				AuthenticationPassphrase: tt.user.AuthPassphrase,
+				PrivacyProtocol:          orNoPriv(tt.user.PrivProtocol),
+				PrivacyPassphrase:        tt.user.PrivPassphrase,
+			}
+		})
+		result, err := c.Get(context.Background(), []string{sysDescrOID})
+		c.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: Get() error = %v, wantErr %v", tt.user.Name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && string(result.Variables[0].Value.([]byte)) != "Simulated switch" {
+			t.Errorf("%v: unexpected value for %v: %v", tt.user.Name, sysDescrOID, result.Variables[0].Value)
+		}
+	}
+}
+
+func orNoAuth(p gosnmp.SnmpV3AuthProtocol) gosnmp.SnmpV3AuthProtocol {
+	if p == 0 {
+		return gosnmp.NoAuth
+	}
+	return p
+}
+
+func orNoPriv(p gosnmp.SnmpV3PrivProtocol) gosnmp.SnmpV3PrivProtocol {
+	if p == 0 {
+		return gosnmp.NoPriv
+	}
+	return p
+}
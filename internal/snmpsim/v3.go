@@ -0,0 +1,141 @@
+package snmpsim
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// usmStatsUnknownEngineIDs is reported to SNMPv3 requests for another engine,
+// which is how managers discover the engine ID of an agent.
+const usmStatsUnknownEngineIDs = ".1.3.6.1.6.3.15.1.1.4.0"
+
+// User is an SNMPv3 user of an Agent.
+type User struct {
+	Name           string
+	AuthProtocol   gosnmp.SnmpV3AuthProtocol
+	AuthPassphrase string
+	PrivProtocol   gosnmp.SnmpV3PrivProtocol
+	PrivPassphrase string
+}
+
+// AddUser adds an SNMPv3 user that the agent answers requests from.
+func (a *Agent) AddUser(u User) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if u.AuthProtocol == 0 {
+		u.AuthProtocol = gosnmp.NoAuth
+	}
+	if u.PrivProtocol == 0 {
+		u.PrivProtocol = gosnmp.NoPriv
+	}
+	a.users[u.Name] = u
+}
+
+// usm returns the security parameters of a user, localized to the agent.
+func (a *Agent) usm(u User) *gosnmp.UsmSecurityParameters {
+	return &gosnmp.UsmSecurityParameters{
+		UserName:                 u.Name,
+		AuthoritativeEngineID:    a.EngineID,
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  uint32(time.Since(a.start).Seconds()),
+		AuthenticationProtocol:   u.AuthProtocol,
+		AuthenticationPassphrase: u.AuthPassphrase,
+		PrivacyProtocol:          u.PrivProtocol,
+		PrivacyPassphrase:        u.PrivPassphrase,
+	}
+}
+
+// decodeV3 decodes and authenticates an SNMPv3 request as coming from u. It
+// returns nil if the request is not from u, is not authentic or can't be
+// decoded.
+func (a *Agent) decodeV3(req []byte, u User) (packet *gosnmp.SnmpPacket) {
+	// gosnmp panics when checking the digest of an authenticated request
+	// against a user without an authentication protocol.
+	defer func() {
+		if recover() != nil {
+			packet = nil
+		}
+	}()
+	x := &gosnmp.GoSNMP{
+		Version:            gosnmp.Version3,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		SecurityParameters: a.usm(u),
+	}
+	// Decoding blanks the digest in the request, so a copy is decoded.
+	packet = x.UnmarshalTrap(append([]byte{}, req...), true)
+	if packet == nil {
+		return nil
+	}
+	usm := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if usm.AuthoritativeEngineID == a.EngineID && usm.UserName != u.Name {
+		return nil
+	}
+	if packet.MsgFlags&gosnmp.AuthNoPriv != 0 && u.AuthProtocol == gosnmp.NoAuth {
+		return nil
+	}
+	if packet.MsgFlags&gosnmp.AuthPriv == gosnmp.AuthPriv && u.PrivProtocol == gosnmp.NoPriv {
+		return nil
+	}
+	return packet
+}
+
+// handleV3 returns the response to an SNMPv3 request, or nil if there should be
+// none. A request for another engine, such as a discovery request, is answered
+// with a report of the agent's engine ID.
+func (a *Agent) handleV3(req []byte) []byte {
+	var packet *gosnmp.SnmpPacket
+	var user User
+	for _, u := range a.users {
+		packet = a.decodeV3(req, u)
+		if packet != nil {
+			user = u
+			break
+		}
+	}
+	if packet == nil {
+		return nil
+	}
+
+	usm := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	resp := &gosnmp.SnmpPacket{
+		Version:         gosnmp.Version3,
+		MsgID:           packet.MsgID,
+		MsgFlags:        packet.MsgFlags &^ gosnmp.Reportable,
+		SecurityModel:   gosnmp.UserSecurityModel,
+		ContextEngineID: a.EngineID,
+		ContextName:     packet.ContextName,
+		RequestID:       packet.RequestID,
+	}
+	if usm.AuthoritativeEngineID != a.EngineID {
+		resp.MsgFlags = gosnmp.NoAuthNoPriv
+		resp.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			AuthoritativeEngineID:    a.EngineID,
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  uint32(time.Since(a.start).Seconds()),
+		}
+		resp.PDUType = gosnmp.Report
+		resp.Variables = []gosnmp.SnmpPDU{
+			{Name: usmStatsUnknownEngineIDs, Type: gosnmp.Counter32, Value: uint32(1)},
+		}
+	} else {
+		if packet.PDUType == gosnmp.GetBulkRequest && a.noBulk {
+			return nil
+		}
+		respUsm := a.usm(user)
+		respUsm.SecretKey = usm.SecretKey
+		respUsm.PrivacyKey = usm.PrivacyKey
+		respUsm.PrivacyParameters = make([]byte, 8)
+		rand.Read(respUsm.PrivacyParameters)
+		resp.SecurityParameters = respUsm
+		resp.PDUType = gosnmp.GetResponse
+		resp.Variables = a.respond(packet)
+	}
+
+	data, err := resp.MarshalMsg()
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,69 @@
+// Package logging sets up structured, leveled logging as JSON, and limits how
+// often repetitive messages are logged.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+)
+
+// Setup makes the default logger write JSON to w, at level and above: one of
+// "debug", "info", "warn" or "error". Messages logged with the standard log
+// package are written as JSON too, at the info level.
+func Setup(w io.Writer, level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})))
+	return nil
+}
+
+// Limiter limits messages with the same key, such as the same error on every
+// poll of an unreachable switch, to one per interval. It is safe for
+// concurrent use.
+type Limiter struct {
+	clock    clock.Clock
+	interval time.Duration
+	mutex    sync.Mutex
+	keys     map[string]*limit
+}
+
+type limit struct {
+	logged     time.Time
+	suppressed int
+}
+
+// NewLimiter returns a Limiter that allows one message per key every interval
+// according to c.
+func NewLimiter(c clock.Clock, interval time.Duration) *Limiter {
+	return &Limiter{
+		clock:    c,
+		interval: interval,
+		keys:     make(map[string]*limit),
+	}
+}
+
+// Allow reports whether a message with key should be logged now and, if so,
+// how many messages with key were suppressed since the last one that was.
+func (l *Limiter) Allow(key string) (bool, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	k, ok := l.keys[key]
+	if !ok {
+		l.keys[key] = &limit{logged: now}
+		return true, 0
+	}
+	if now.Sub(k.logged) < l.interval {
+		k.suppressed++
+		return false, 0
+	}
+	suppressed := k.suppressed
+	k.logged, k.suppressed = now, 0
+	return true, suppressed
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+)
+
+func Test_Setup(t *testing.T) {
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+
+	if err := Setup(&bytes.Buffer{}, "verbose"); err == nil {
+		t.Errorf("Expected Setup() to reject an unknown level")
+	}
+
+	out := &bytes.Buffer{}
+	if err := Setup(out, "warn"); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	slog.Info("Not logged")
+	slog.Warn("Polling failed", "target", "s1-abc0t.measurement-lab.org", "oid", ".1.3.6.1.2.1.1.3.0")
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Could not decode the log %q: %v", out, err)
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "Polling failed" || entry["target"] != "s1-abc0t.measurement-lab.org" {
+		t.Errorf("Logged %v", entry)
+	}
+
+	// The standard logger logs JSON at the info level, so it is filtered too.
+	out.Reset()
+	log.Printf("Not logged either")
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing to be logged below the level, got %q", out)
+	}
+}
+
+func Test_Limiter(t *testing.T) {
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	l := NewLimiter(f, time.Minute)
+
+	if ok, _ := l.Allow("timeout"); !ok {
+		t.Errorf("Expected the first message to be allowed")
+	}
+	for i := 0; i < 5; i++ {
+		f.Advance(10 * time.Second)
+		if ok, _ := l.Allow("timeout"); ok {
+			t.Errorf("Expected a repeated message at %v to be suppressed", f.Now())
+		}
+	}
+	if ok, _ := l.Allow("walk"); !ok {
+		t.Errorf("Expected a message with another key to be allowed")
+	}
+	f.Advance(10 * time.Second)
+	if ok, suppressed := l.Allow("timeout"); !ok || suppressed != 5 {
+		t.Errorf("Allow() after the interval = %v, %v, want true, 5", ok, suppressed)
+	}
+	if ok, _ := l.Allow("timeout"); ok {
+		t.Errorf("Expected the interval to start again")
+	}
+}
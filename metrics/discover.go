@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nkinkade/disco-go/snmp"
+)
+
+// Candidate is an interface of the switch considered by Discover. Scope is
+// "machine" or "uplink" if its ifAlias matches one of those, and Selected is
+// true if it is the interface used for that scope. Reason explains either.
+type Candidate struct {
+	IfIndex  string `json:"ifIndex"`
+	IfAlias  string `json:"ifAlias"`
+	Scope    string `json:"scope,omitempty"`
+	Selected bool   `json:"selected"`
+	Reason   string `json:"reason"`
+}
+
+// Discovery is the result of Discover: the interfaces selected, keyed by
+// scope, and every interface that was considered, in ifIndex order.
+type Discovery struct {
+	Machine    string               `json:"machine"`
+	Interfaces map[string]Interface `json:"interfaces"`
+	Candidates []Candidate          `json:"candidates"`
+}
+
+// Discover finds the interfaces of the switch to collect metrics for by their
+// ifAlias: the machine interface has the name of the machine, such as "mlab2",
+// and the uplink interface a name starting with "uplink". If several
+// interfaces match, the last one is used. A scope missing from the Interfaces
+// of the result was not found.
+func Discover(ctx context.Context, snmp snmp.SNMP, machine string) (Discovery, error) {
+	pdus, err := snmp.BulkWalkAll(ctx, ifAliasOid)
+	if err != nil {
+		return Discovery{}, fmt.Errorf("failed to walk the ifAlias OID: %v", err)
+	}
+
+	d := Discovery{Machine: machine, Interfaces: make(map[string]Interface)}
+	selected := make(map[string]int)
+	for _, pdu := range pdus {
+		oidParts := strings.Split(pdu.Name, ".")
+		b, _ := pdu.Value.([]byte)
+		c := Candidate{
+			IfIndex: oidParts[len(oidParts)-1],
+			IfAlias: strings.TrimSpace(string(b)),
+		}
+		switch {
+		case c.IfAlias == machine:
+			c.Scope, c.Reason = "machine", fmt.Sprintf("ifAlias is the machine name %q", machine)
+		case strings.HasPrefix(c.IfAlias, "uplink"):
+			c.Scope, c.Reason = "uplink", "ifAlias starts with \"uplink\""
+		default:
+			c.Reason = fmt.Sprintf("ifAlias is neither the machine name %q nor starts with \"uplink\"", machine)
+		}
+		if c.Scope != "" {
+			if i, ok := selected[c.Scope]; ok {
+				d.Candidates[i].Selected = false
+				d.Candidates[i].Reason += fmt.Sprintf(", but ifIndex %v, which also matches, is used instead", c.IfIndex)
+			}
+			c.Selected = true
+			selected[c.Scope] = len(d.Candidates)
+		}
+		d.Candidates = append(d.Candidates, c)
+	}
+
+	for scope, i := range selected {
+		c := d.Candidates[i]
+		ifDescrOid := createOID(ifDescrOidStub, c.IfIndex)
+		oidMap, err := getOidsString(ctx, snmp, []string{ifDescrOid})
+		if err != nil {
+			return d, fmt.Errorf("failed to determine the %v interface ifDescr: %v", scope, err)
+		}
+		d.Interfaces[scope] = Interface{IfIndex: c.IfIndex, IfDescr: oidMap[ifDescrOid], IfAlias: c.IfAlias}
+	}
+	return d, nil
+}
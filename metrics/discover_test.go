@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nkinkade/disco-go/snmp"
+)
+
+func Test_Discover(t *testing.T) {
+	replay, err := snmp.LoadReplay("testdata/juniper-qfx5100.snmprec")
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+	d, err := Discover(context.Background(), replay, "mlab2")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	want := map[string]Interface{
+		"machine": {IfIndex: "524", IfDescr: "xe-0/0/12", IfAlias: "mlab2"},
+		"uplink":  {IfIndex: "568", IfDescr: "xe-0/0/45", IfAlias: "uplink-10g"},
+	}
+	for scope, iface := range want {
+		if d.Interfaces[scope] != iface {
+			t.Errorf("Interfaces[%v] = %+v, want %+v", scope, d.Interfaces[scope], iface)
+		}
+	}
+	if len(d.Candidates) != 4 {
+		t.Fatalf("Expected 4 candidates, got %+v", d.Candidates)
+	}
+	if c := d.Candidates[1]; c.Selected || c.Scope != "" || !strings.Contains(c.Reason, "neither") {
+		t.Errorf("Expected ifIndex 525 to be passed over, got %+v", c)
+	}
+
+	// Without a machine interface, and with two uplinks.
+	replay, err = snmp.NewReplay(strings.NewReader(`
+1.3.6.1.2.1.2.2.1.2.10|4|et-0/0/1
+1.3.6.1.2.1.2.2.1.2.20|4|et-0/0/2
+1.3.6.1.2.1.31.1.1.1.18.10|4|uplink-a
+1.3.6.1.2.1.31.1.1.1.18.20|4|uplink-b
+1.3.6.1.2.1.31.1.1.1.18.30|4|mlab3
+`))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+	d, err = Discover(context.Background(), replay, "mlab2")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if _, ok := d.Interfaces["machine"]; ok {
+		t.Errorf("Expected no machine interface, got %+v", d.Interfaces["machine"])
+	}
+	if got := d.Interfaces["uplink"]; got.IfIndex != "20" || got.IfDescr != "et-0/0/2" {
+		t.Errorf("Expected the last uplink to be used, got %+v", got)
+	}
+	if c := d.Candidates[0]; c.Selected || c.Scope != "uplink" || !strings.Contains(c.Reason, "ifIndex 20") {
+		t.Errorf("Expected the first uplink to be passed over for the second, got %+v", c)
+	}
+}
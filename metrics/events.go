@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/nkinkade/disco-go/archive"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Link up and down notifications from IF-MIB, which are always handled.
+const (
+	linkDownOid = ".1.3.6.1.6.3.1.1.5.3"
+	linkUpOid   = ".1.3.6.1.6.3.1.1.5.4"
+)
+
+// ifEntryOids are the tables whose variables in a notification identify the
+// interface it is about, by the ifIndex at the end of their OIDs.
+var ifEntryOids = []string{
+	".1.3.6.1.2.1.2.2.1.",
+	".1.3.6.1.2.1.31.1.1.1.",
+}
+
+var snmpEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "disco_snmp_events_total",
+		Help: "The number of SNMP notifications received from the switch, by event and interface. Events for interfaces other than the machine's and the uplink have no interface.",
+	},
+	[]string{"node", "event", "interface"},
+)
+
+// Events counts and archives the notifications received from a switch, such
+// as links going up and down, correlating them with the machine and uplink
+// interfaces of a Metrics.
+type Events struct {
+	metrics *Metrics
+	names   map[string]string
+	events  []archive.Event
+	// flushed is the end of the events last written, as a Unix time.
+	flushed int64
+	mutex   sync.Mutex
+}
+
+// NewEvents returns Events for the notifications of the switch that metrics
+// collects from. Notifications are named by names, keyed by their numeric
+// OID, in addition to linkDown and linkUp. Other notifications are counted
+// with the event "other" and not archived.
+func NewEvents(metrics *Metrics, names map[string]string) *Events {
+	e := &Events{
+		metrics: metrics,
+		names: map[string]string{
+			linkDownOid: "linkDown",
+			linkUpOid:   "linkUp",
+		},
+	}
+	for oid, name := range names {
+		e.names[oid] = name
+	}
+	return e
+}
+
+// Handle counts a notification and buffers it for the next Write.
+func (e *Events) Handle(n snmp.Notification) {
+	ifIndex := notificationIfIndex(n)
+	scope, ifDescr := e.metrics.Interface(ifIndex)
+
+	name, ok := e.names[n.TrapOID]
+	if !ok {
+		snmpEvents.WithLabelValues(e.metrics.hostname, "other", ifDescr).Inc()
+		return
+	}
+	snmpEvents.WithLabelValues(e.metrics.hostname, name, ifDescr).Inc()
+	e.metrics.log.Info("SNMP notification", "event", name, "source", n.Source, "ifIndex", ifIndex, "scope", scope)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.events = append(e.events, archive.Event{
+		Experiment: e.metrics.target,
+		Hostname:   e.metrics.hostname,
+		Event:      name,
+		Timestamp:  e.metrics.clock.Now().Unix(),
+		Source:     fmt.Sprint(n.Source),
+		IfIndex:    ifIndex,
+		IfDescr:    ifDescr,
+		Interface:  scope,
+	})
+}
+
+// Write writes the events received in each write interval of interval seconds
+// that has ended to an archive of its own, named and placed as for the
+// archives of Metrics.Write. Nothing is written for an interval without
+// events. Events received in the interval in progress stay buffered for the
+// next Write.
+func (e *Events) Write(interval uint64) {
+	e.write(interval, intervalStart(e.metrics.clock.Now().Unix(), interval))
+}
+
+// Flush writes all of the buffered events, as on shutdown. The events of the
+// interval in progress are archived as the part of the interval up to now,
+// and the archive written when it ends covers only the rest of it, as for
+// Metrics.Flush. Events received in the current second are included.
+func (e *Events) Flush(interval uint64) {
+	e.write(interval, e.metrics.clock.Now().Unix()+1)
+}
+
+// write writes the events received before end, which is either the end of an
+// interval or just after the time of a Flush.
+func (e *Events) write(interval uint64, end int64) {
+	e.mutex.Lock()
+	done := make(map[int64][]archive.Event)
+	pending := []archive.Event{}
+	for _, event := range e.events {
+		if event.Timestamp >= end {
+			pending = append(pending, event)
+			continue
+		}
+		start := intervalStart(event.Timestamp, interval)
+		done[start] = append(done[start], event)
+	}
+	e.events = pending
+	// An interval that was partly flushed is archived from the flush on.
+	flushed := e.flushed
+	e.flushed = end
+	e.mutex.Unlock()
+
+	starts := make([]int64, 0, len(done))
+	for start := range done {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		var jsonData []byte
+		for _, event := range done[start] {
+			data, err := archive.GetEventJSON(event)
+			rtx.Must(err, "Failed to GetEventJSON for an event")
+			jsonData = append(jsonData, data...)
+		}
+
+		from, to := start, start+int64(interval)
+		if flushed > from && flushed < to {
+			from = flushed
+		}
+		if end < to {
+			to = end
+		}
+		archivePath := archive.GetEventsPath(time.Unix(start, 0).UTC(), e.metrics.hostname, interval)
+		if from != start || to != start+int64(interval) {
+			archivePath = archive.GetEventsRangePath(time.Unix(from, 0).UTC(), time.Unix(to, 0).UTC(), e.metrics.hostname)
+		}
+		err := archive.Write(archivePath, jsonData)
+		if err != nil {
+			rtx.Must(err, "Failed to write events archive")
+		}
+	}
+}
+
+// notificationIfIndex returns the ifIndex of the interface a notification is
+// about, as given by any variable of the ifTable or ifXTable it carries, or ""
+// if it has none.
+func notificationIfIndex(n snmp.Notification) string {
+	for _, pdu := range n.Variables {
+		name := "." + strings.TrimPrefix(pdu.Name, ".")
+		for _, stub := range ifEntryOids {
+			if strings.HasPrefix(name, stub) {
+				parts := strings.Split(name, ".")
+				return parts[len(parts)-1]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/archive"
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/soniah/gosnmp"
+)
+
+func notification(trapOid string, ifIndex string) snmp.Notification {
+	n := snmp.Notification{
+		Source:  net.IPv4(192, 168, 0, 1),
+		TrapOID: trapOid,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(123)},
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: trapOid},
+		},
+	}
+	if ifIndex != "" {
+		n.Variables = append(n.Variables,
+			gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.8." + ifIndex, Type: gosnmp.Integer, Value: 2})
+	}
+	return n
+}
+
+func Test_Events(t *testing.T) {
+	snmpEvents.Reset()
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	_, m := newReplayMetrics(t, c, f)
+	configChange := ".1.3.6.1.2.1.47.2.0.1"
+	e := NewEvents(m, map[string]string{configChange: "entConfigChange"})
+
+	e.Handle(notification(linkDownOid, "524"))
+	e.Handle(notification(linkUpOid, "568"))
+	e.Handle(notification(linkDownOid, "1"))
+	e.Handle(notification(configChange, ""))
+	e.Handle(notification(".1.3.6.1.6.3.1.1.5.1", ""))
+
+	counts := map[[2]string]float64{
+		{"linkDown", "xe-0/0/12"}: 1,
+		{"linkUp", "xe-0/0/45"}:   1,
+		{"linkDown", ""}:          1,
+		{"entConfigChange", ""}:   1,
+		{"other", ""}:             1,
+	}
+	for labels, want := range counts {
+		got := testutil.ToFloat64(snmpEvents.WithLabelValues(hostname, labels[0], labels[1]))
+		if got != want {
+			t.Errorf("disco_snmp_events_total%v = %v, want %v", labels, got, want)
+		}
+	}
+
+	received := f.Now().Unix()
+	// The events are held until the interval they were received in ends.
+	archivePath := archive.GetEventsPath(f.Now(), hostname, 10)
+	defer os.RemoveAll("2020")
+	e.Write(10)
+	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+		t.Fatalf("Expected no events archive before the interval ended, got: %v", err)
+	}
+	f.Advance(10 * time.Second)
+	e.Write(10)
+
+	events := readEvents(t, archivePath)
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 archived events, got %v", len(events))
+	}
+	want := archive.Event{
+		Experiment: target,
+		Hostname:   hostname,
+		Event:      "linkDown",
+		Timestamp:  received,
+		Source:     "192.168.0.1",
+		IfIndex:    "524",
+		IfDescr:    "xe-0/0/12",
+		Interface:  "machine",
+	}
+	if events[0] != want {
+		t.Errorf("First archived event = %+v, want %+v", events[0], want)
+	}
+	if events[2].Interface != "" || events[2].IfIndex != "1" {
+		t.Errorf("Expected an event on another interface to have no scope, got %+v", events[2])
+	}
+
+	// Nothing is written once the events have been archived.
+	os.RemoveAll("2020")
+	e.Write(10)
+	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+		t.Errorf("Expected no events archive to be written, got: %v", err)
+	}
+
+	// Flush writes the events of the interval in progress as far as it has
+	// gone, and the archive written when the interval ends has only the rest,
+	// so that neither overwrites the other.
+	f.Advance(5 * time.Second)
+	e.Handle(notification(linkUpOid, "524"))
+	e.Flush(10)
+	flushedPath := "2020/06/11/" + hostname + "/2020-06-11T12:00:10-to-2020-06-11T12:00:16-switch-events.json"
+	if events := readEvents(t, flushedPath); len(events) != 1 || events[0].Event != "linkUp" {
+		t.Errorf("Expected the linkUp event in %v, got %+v", flushedPath, events)
+	}
+	f.Advance(2 * time.Second)
+	e.Handle(notification(linkDownOid, "524"))
+	f.Advance(3 * time.Second)
+	e.Write(10)
+	restPath := "2020/06/11/" + hostname + "/2020-06-11T12:00:16-to-2020-06-11T12:00:20-switch-events.json"
+	if events := readEvents(t, restPath); len(events) != 1 || events[0].Event != "linkDown" {
+		t.Errorf("Expected the linkDown event in %v, got %+v", restPath, events)
+	}
+	fullPath := archive.GetEventsPath(f.Now().Add(-10*time.Second), hostname, 10)
+	if _, err := os.Stat(fullPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive of the whole interval, got: %v", err)
+	}
+}
+
+// readEvents returns the events in the archive at path.
+func readEvents(t *testing.T, path string) []archive.Event {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read the events archive: %v", err)
+	}
+	events := []archive.Event{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	for decoder.More() {
+		event := archive.Event{}
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("Could not decode the events archive: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
@@ -1,121 +1,359 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/m-lab/go/rtx"
 	"github.com/nkinkade/disco-go/archive"
+	"github.com/nkinkade/disco-go/clock"
 	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/logging"
 	"github.com/nkinkade/disco-go/snmp"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/soniah/gosnmp"
 )
 
 const (
 	ifAliasOid     = ".1.3.6.1.2.1.31.1.1.1.18"
 	ifDescrOidStub = ".1.3.6.1.2.1.2.2.1.2"
+
+	// maxErrorIndex is the largest error-index an SNMP packet can hold.
+	maxErrorIndex = math.MaxUint8
+)
+
+var (
+	oidsUnavailable = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "disco_oids_unavailable_total",
+			Help: "The number of times an OID could not be collected, by metric, OID and reason.",
+		},
+		[]string{"metric", "oid", "reason"},
+	)
+	collectOverruns = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "disco_collect_overruns_total",
+			Help: "The number of collections that were not started because the previous one was still running.",
+		},
+	)
 )
 
+// ErrCollectionInProgress is returned by Collect and CollectDue when the
+// previous collection is still running.
+var ErrCollectionInProgress = errors.New("the previous collection is still running")
+
 // Metrics represents a collection of oids, plus additional data about the environment.
 type Metrics struct {
-	oids     map[string]oid
-	prom     map[string]*prometheus.CounterVec
+	oids      map[string]oid
+	tables    []config.Metric
+	prom      map[string]*prometheus.CounterVec
+	promGauge map[string]*prometheus.GaugeVec
+	config    config.Config
+	ifaces    map[string]map[string]string
+	// retired holds the unwritten samples of series removed by a Reload.
+	retired  []archive.Model
 	hostname string
 	machine  string
-	mutex    sync.Mutex
-	// TODO(kinkade): remove this field in favor of a more elegant solution.
-	firstRun bool
+	target   string
+	// clock is the source of sample timestamps and archive times.
+	clock clock.Clock
+	// collected is when the last collection completed.
+	collected time.Time
+	// flushed is the end of the samples last written, as a Unix time.
+	flushed int64
+	// lastArchive is the path of the last archive written, at lastArchived.
+	lastArchive  string
+	lastArchived time.Time
+	// recentErrors are the most recent errors, oldest first. log has the
+	// target as a field, and limiter limits how often the same error is
+	// logged.
+	recentErrors []Error
+	log          *slog.Logger
+	limiter      *logging.Limiter
+	// mutex guards the series, and is only held briefly. collectMutex is held
+	// for the whole of a collection or Reload, and collecting is set while a
+	// collection is running.
+	mutex        sync.Mutex
+	collectMutex sync.Mutex
+	collecting   int32
 }
 
+// oid represents a single time series. The scope of an oid is "machine" or
+// "uplink" for interface metrics, or otherwise the mode of the metric. The
+// interval is how often the series is collected, or zero for every poll.
 type oid struct {
 	name           string
-	previousValue  uint64
+	counter        bool
+	hasPrevious    bool
+	previousValue  int64
+	delta          int64
 	scope          string
 	ifDescr        string
+	labels         []string
+	interval       time.Duration
 	intervalSeries archive.Model
 }
 
-// getIfaces uses an ifAlias value to determine the logical interface number and
-// description for the machine's interface and the switch's uplink.
-func getIfaces(snmp snmp.SNMP, machine string) map[string]map[string]string {
-	pdus, err := snmp.BulkWalkAll(ifAliasOid)
-	rtx.Must(err, "Failed to walk the ifAlias OID")
-
-	ifaces := map[string]map[string]string{
-		"machine": map[string]string{
-			"iface":   "",
-			"ifDescr": "",
-		},
-		"uplink": map[string]string{
-			"iface":   "",
-			"ifDescr": "",
-		},
+// due reports whether a series collected every interval is due to be collected
+// by the poll at tick. Series are collected when tick is a multiple of their
+// interval, so that, like polls, they fall on wall clock boundaries.
+func due(interval time.Duration, tick time.Time) bool {
+	return interval <= 0 || tick.Truncate(interval).Equal(tick)
+}
+
+// labelValues returns the Prometheus label values for the oid.
+func (o oid) labelValues(node string) []string {
+	switch o.scope {
+	case config.Scalar, config.Table:
+		return append([]string{node}, o.labels...)
 	}
+	return []string{node, o.ifDescr}
+}
 
-	for _, pdu := range pdus {
-		oidParts := strings.Split(pdu.Name, ".")
-		iface := oidParts[len(oidParts)-1]
+// getIfaces uses an ifAlias value to determine the logical interface number and
+// ifDescr of the machine and uplink interfaces, as found by Discover.
+func getIfaces(ctx context.Context, snmp snmp.SNMP, machine string) (map[string]map[string]string, error) {
+	d, err := Discover(ctx, snmp, machine)
+	if err != nil {
+		return nil, err
+	}
 
-		b := pdu.Value.([]byte)
-		val := strings.TrimSpace(string(b))
-		if val == machine {
-			ifDescrOid := createOID(ifDescrOidStub, iface)
-			oidMap, err := getOidsString(snmp, []string{ifDescrOid})
-			rtx.Must(err, "Failed to determine the machine interface ifDescr")
-			ifaces["machine"]["ifDescr"] = oidMap[ifDescrOid]
-			ifaces["machine"]["iface"] = iface
+	ifaces := make(map[string]map[string]string)
+	for _, scope := range []string{"machine", "uplink"} {
+		iface := d.Interfaces[scope]
+		ifaces[scope] = map[string]string{
+			"iface":   iface.IfIndex,
+			"ifDescr": iface.IfDescr,
+			"ifAlias": iface.IfAlias,
 		}
-		if strings.HasPrefix(val, "uplink") {
-			ifDescrOid := createOID(ifDescrOidStub, iface)
-			oidMap, err := getOidsString(snmp, []string{ifDescrOid})
-			rtx.Must(err, "Failed to determine the uplink interface ifDescr")
-			ifaces["uplink"]["ifDescr"] = oidMap[ifDescrOid]
-			ifaces["uplink"]["iface"] = iface
+	}
+	return ifaces, nil
+}
+
+// Interface returns the scope, "machine" or "uplink", and the ifDescr of the
+// interface with ifIndex, or empty strings if it is neither of those.
+func (metrics *Metrics) Interface(ifIndex string) (string, string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if ifIndex == "" {
+		return "", ""
+	}
+	for scope, values := range metrics.ifaces {
+		if values["iface"] == ifIndex {
+			return scope, values["ifDescr"]
 		}
 	}
+	return "", ""
+}
 
-	return ifaces
+// Discovered returns an error if the machine or uplink interface was not found
+// on the switch, so that their metrics cannot be collected.
+func (metrics *Metrics) Discovered() error {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	missing := []string{}
+	if metrics.ifaces["machine"]["iface"] == "" {
+		missing = append(missing, fmt.Sprintf("the machine interface, with ifAlias %q", metrics.machine))
+	}
+	if metrics.ifaces["uplink"]["iface"] == "" {
+		missing = append(missing, "the uplink interface, with an ifAlias starting with \"uplink\"")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("could not find %v", strings.Join(missing, " or "))
+	}
+	return nil
+}
+
+// Collected returns an error if no collection has completed within the last
+// period, as when the switch is unreachable.
+func (metrics *Metrics) Collected(period time.Duration) error {
+	metrics.mutex.Lock()
+	collected := metrics.collected
+	metrics.mutex.Unlock()
+	if collected.IsZero() {
+		return fmt.Errorf("no collection from %v has completed yet", metrics.target)
+	}
+	if since := metrics.clock.Now().Sub(collected); since > period {
+		return fmt.Errorf("the last collection from %v completed %v ago, more than %v", metrics.target, since, period)
+	}
+	return nil
+}
+
+// Subtrees returns the OID subtrees that collecting the metrics of c reads: the
+// ifAlias and ifDescr columns used to find the machine and uplink interfaces,
+// and the OID stub and label OIDs of each metric. Walking these subtrees
+// records everything needed to replay collection from a switch.
+func Subtrees(c config.Config) []string {
+	subtrees := []string{ifAliasOid, ifDescrOidStub}
+	seen := map[string]bool{ifAliasOid: true, ifDescrOidStub: true}
+	add := func(oid string) {
+		if !seen[oid] {
+			seen[oid] = true
+			subtrees = append(subtrees, oid)
+		}
+	}
+	for _, metric := range c.Metrics {
+		add(metric.OidStub)
+		for _, label := range tableLabelNames(metric)[1:] {
+			add(metric.LabelOids[label])
+		}
+	}
+	return subtrees
 }
 
 // getOidsString accepts a list of OIDS and returns a map of the OIDs to their
 // string values.
-func getOidsString(snmp snmp.SNMP, oids []string) (map[string]string, error) {
+func getOidsString(ctx context.Context, snmp snmp.SNMP, oids []string) (map[string]string, error) {
 	oidMap := make(map[string]string)
-	result, err := snmp.Get(oids)
+	result, err := snmp.Get(ctx, oids)
+	if result == nil {
+		return oidMap, err
+	}
 	for _, pdu := range result.Variables {
-		oidMap[pdu.Name] = string(pdu.Value.([]byte))
+		switch value := pdu.Value.(type) {
+		case []byte:
+			oidMap[pdu.Name] = string(value)
+		default:
+			oidMap[pdu.Name] = fmt.Sprint(value)
+		}
 	}
 	return oidMap, err
 }
 
+// reading is an int-type value collected from the switch, cast to an int64,
+// along with its SNMP type.
+type reading struct {
+	value int64
+	typ   gosnmp.Asn1BER
+}
+
 // getOidsInt64 accepts a list of OIDS and returns a map of the OIDs to their
-// various int-type values, with all values being cast to a uint64.
+// various int-type values, with all values being cast to an int64, and a map of
+// the OIDs whose values could not be collected to the reason why. An error is
+// only returned if the request as a whole failed.
 //
-// Counter32 OIDs seem to be presented as type uint, while Counter64 OIDs seem
-// to be presented as type uint64.
-func getOidsInt64(snmp snmp.SNMP, oids []string) (map[string]uint64, error) {
-	oidMap := make(map[string]uint64)
-	result, err := snmp.Get(oids)
-	if result == nil {
-		err = fmt.Errorf("No results returned from server for oids: %v", oids)
-		return nil, err
+// Counter32 and Gauge32 OIDs seem to be presented as type uint, Counter64 OIDs
+// as type uint64, TimeTicks OIDs as type uint32 and Integer OIDs as type int.
+// A Counter64 larger than the maximum int64 wraps around to a negative number,
+// so counterIncrease compares Counter64 values as uint64s.
+//
+// SNMPv2 agents report OIDs they don't have as NoSuchObject or NoSuchInstance
+// varbinds, while SNMPv1 agents fail the whole request with an error-status
+// and the index of the offending OID. In the latter case the request is
+// retried without that OID. The error-index of a packet only holds values up
+// to 255, so the OIDs are requested at most maxErrorIndex at a time for the
+// index to always point at the offending OID.
+func getOidsInt64(ctx context.Context, snmp snmp.SNMP, oids []string) (map[string]reading, map[string]string, error) {
+	oidMap := make(map[string]reading)
+	unavailable := make(map[string]string)
+	for start := 0; start < len(oids); start += maxErrorIndex {
+		end := start + maxErrorIndex
+		if end > len(oids) {
+			end = len(oids)
+		}
+		err := getChunkInt64(ctx, snmp, oids[start:end], oidMap, unavailable)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	for _, pdu := range result.Variables {
-		switch value := pdu.Value.(type) {
-		case uint:
-			oidMap[pdu.Name] = uint64(value)
-		case uint64:
-			oidMap[pdu.Name] = value
-		default:
-			err = fmt.Errorf("Unknown type %T of SNMP type %v for OID %v", value, pdu.Type, pdu.Name)
-			return nil, err
+	return oidMap, unavailable, nil
+}
+
+// getChunkInt64 requests oids, retrying without any OID an SNMPv1 agent fails
+// the request for, and adds the values and unavailable OIDs to oidMap and
+// unavailable.
+func getChunkInt64(ctx context.Context, snmp snmp.SNMP, oids []string, oidMap map[string]reading, unavailable map[string]string) error {
+	for len(oids) > 0 {
+		result, err := snmp.Get(ctx, oids)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			return fmt.Errorf("No results returned from server for oids: %v", oids)
+		}
+		if result.Error != gosnmp.NoError {
+			index := int(result.ErrorIndex)
+			if index < 1 || index > len(oids) {
+				return fmt.Errorf("Error %v returned from server for oids: %v", result.Error, oids)
+			}
+			unavailable[oids[index-1]] = result.Error.String()
+			oids = append(append([]string{}, oids[:index-1]...), oids[index:]...)
+			continue
+		}
+		for _, pdu := range result.Variables {
+			value, pduErr := pduInt64(pdu)
+			if pduErr != nil {
+				unavailable[pdu.Name] = pduReason(pdu)
+				continue
+			}
+			oidMap[pdu.Name] = reading{value: value, typ: pdu.Type}
 		}
+		break
 	}
-	return oidMap, err
+	return nil
+}
+
+// pduReason returns the reason the value of a PDU that is not int-type could
+// not be collected: the exception for NoSuchObject, NoSuchInstance and
+// EndOfMibView PDUs, or otherwise "UnexpectedType".
+func pduReason(pdu gosnmp.SnmpPDU) string {
+	switch pdu.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return pdu.Type.String()
+	}
+	return "UnexpectedType"
+}
+
+// recordUnavailable counts each OID of metric that could not be collected,
+// along with the reason why, and logs it as a warning with the scope of the
+// series.
+func (metrics *Metrics) recordUnavailable(metric string, oid string, scope string, reason string) {
+	oidsUnavailable.WithLabelValues(metric, oid, reason).Inc()
+	metrics.logLimited(slog.LevelWarn, "OID is unavailable", errors.New(reason), "metric", metric, "oid", oid, "scope", scope)
+}
+
+// pduInt64 returns the value of an int-type PDU cast to an int64.
+func pduInt64(pdu gosnmp.SnmpPDU) (int64, error) {
+	switch value := pdu.Value.(type) {
+	case int:
+		return int64(value), nil
+	case uint:
+		return int64(value), nil
+	case uint32:
+		return int64(value), nil
+	case uint64:
+		return int64(value), nil
+	default:
+		return 0, fmt.Errorf("Unknown type %T of SNMP type %v for OID %v", value, pdu.Type, pdu.Name)
+	}
+}
+
+// counterIncrease returns the increase of a counter from previous to r, or
+// false if the counter was reset. A Counter32 that decreased is taken to have
+// wrapped around at 2^32, as ifInErrors and the like do on a busy interface,
+// while any other counter that decreased was reset.
+func counterIncrease(previous int64, r reading) (int64, bool) {
+	switch {
+	case r.typ == gosnmp.Counter32 && r.value < previous:
+		return r.value - previous + 1<<32, true
+	case r.typ == gosnmp.Counter64:
+		if uint64(r.value) < uint64(previous) {
+			return 0, false
+		}
+		return int64(uint64(r.value) - uint64(previous)), true
+	case r.value < previous:
+		return 0, false
+	}
+	return r.value - previous, true
 }
 
 // createOID joins an OID stub with a logical interface number, returning the
@@ -125,129 +363,414 @@ func createOID(oidStub string, iface string) string {
 }
 
 // Collect scrapes values for a list of OIDs and updates a map of OIDs,
-// appending a new archive.Sample representing the increase from the previous
-// scrape to an array of samples for that OID.
-func (metrics *Metrics) Collect(snmp snmp.SNMP, config config.Config) error {
-	// Set a lock to avoid a race between the collecting and writing of metrics.
-	metrics.mutex.Lock()
-	defer metrics.mutex.Unlock()
+// appending a new archive.Sample to an array of samples for that OID. For
+// counters the sample represents the increase from the previous scrape, while
+// for all other metric types it is the absolute value that was scraped. If ctx
+// is done before the scrape completes then the scrape is abandoned and nothing
+// is recorded. Every series of the configuration the metrics were created or
+// last reloaded with is collected, whatever its interval.
+func (metrics *Metrics) Collect(ctx context.Context, snmp snmp.SNMP) error {
+	return metrics.collect(ctx, snmp, func(time.Duration) bool { return true })
+}
+
+// CollectDue scrapes values as Collect does, but only for the series that are
+// due to be collected by the poll at tick, according to their interval. For
+// counters each sample is the increase since the series was last collected,
+// so the samples of each series reflect its own interval.
+func (metrics *Metrics) CollectDue(ctx context.Context, snmp snmp.SNMP, tick time.Time) error {
+	return metrics.collect(ctx, snmp, func(interval time.Duration) bool { return due(interval, tick) })
+}
+
+// collect scrapes the series whose interval is due. Only one collection runs
+// at a time: one that is started while another is running returns
+// ErrCollectionInProgress at once, rather than queuing behind it. The SNMP
+// requests are made without holding the lock on the series, so a slow switch
+// never blocks Write.
+func (metrics *Metrics) collect(ctx context.Context, snmp snmp.SNMP, isDue func(time.Duration) bool) error {
+	if !atomic.CompareAndSwapInt32(&metrics.collecting, 0, 1) {
+		collectOverruns.Inc()
+		metrics.logError("Skipped a collection", ErrCollectionInProgress)
+		return ErrCollectionInProgress
+	}
+	defer atomic.StoreInt32(&metrics.collecting, 0)
+	// Reload takes collectMutex too, so the set of series and tables only
+	// changes between collections.
+	metrics.collectMutex.Lock()
+	defer metrics.collectMutex.Unlock()
 
+	metrics.mutex.Lock()
 	oids := []string{}
-	for oid := range metrics.oids {
-		oids = append(oids, oid)
+	known := make(map[string]oid)
+	for oid, values := range metrics.oids {
+		known[oid] = values
+		if values.scope != config.Table && isDue(values.interval) {
+			oids = append(oids, oid)
+		}
 	}
-	oidValueMap, err := getOidsInt64(snmp, oids)
-	if err != nil {
-		log.Printf("ERROR: failed to GET OIDs (%v) from SNMP server: %v", oids, err)
-		// TODO(kinkade): increment some sort of error metric here.
-		return err
+	tables := []config.Metric{}
+	for _, table := range metrics.tables {
+		if isDue(table.Interval) {
+			tables = append(tables, table)
+		}
 	}
+	metrics.mutex.Unlock()
 
-	for oid, value := range oidValueMap {
+	oidValueMap := make(map[string]reading)
+	if len(oids) > 0 {
+		values, unavailable, err := getOidsInt64(ctx, snmp, oids)
+		if err != nil {
+			metrics.logError("Failed to GET OIDs from the SNMP server", err, "oids", oids)
+			// TODO(kinkade): increment some sort of error metric here.
+			return err
+		}
+		for oid, reason := range unavailable {
+			metrics.recordUnavailable(known[oid].name, oid, known[oid].scope, reason)
+		}
+		oidValueMap = values
+	}
+
+	newRows := make(map[string]oid)
+	walked := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, table := range tables {
+		err := metrics.walkTable(ctx, snmp, table, known, seen, newRows, oidValueMap)
+		if err != nil {
+			metrics.logError("Failed to walk a table from the SNMP server", err, "metric", table.Name, "oid", table.OidStub, "scope", config.Table)
+			return err
+		}
+		walked[table.Name] = true
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	// The time is read while holding the lock, so that no sample from before
+	// the end of an interval can be added once Write has split the buffers.
+	metrics.collected = metrics.clock.Now()
+	now := metrics.collected.Unix()
+	for oidStr, o := range newRows {
+		metrics.oids[oidStr] = o
+	}
+	metrics.pruneRows(walked, seen)
+	for oid, r := range oidValueMap {
+		value := r.value
 		// This is less than ideal. Because we can't write to a map in a struct
 		// we have to copy the whole map, modify it and then overwrite the
 		// original map. There is likely a better way to do this.
 		metricOid := metrics.oids[oid]
 		metricOid.previousValue = value
+		metricOid.hasPrevious = true
+		labels := metricOid.labelValues(metrics.hostname)
+		metricName := metricOid.name
+
+		// Gauges need no previous value, so are recorded on every run.
+		if !metricOid.counter {
+			metrics.promGauge[metricName].WithLabelValues(labels...).Set(float64(value))
+			metricOid.intervalSeries.Samples = append(
+				metricOid.intervalSeries.Samples,
+				archive.Sample{Timestamp: now, Value: value},
+			)
+			metrics.oids[oid] = metricOid
+			continue
+		}
 
-		// If this is the first run then we have no previousValue with which to
-		// calculate an increase, so we just record a previousValue and return.
-		if metrics.firstRun == true {
+		// If this is the first time the OID was collected then we have no
+		// previousValue with which to calculate an increase, so we just record
+		// a previousValue and move on.
+		if !metrics.oids[oid].hasPrevious {
 			metrics.oids[oid] = metricOid
 			continue
 		}
 
-		increase := value - metrics.oids[oid].previousValue
-		ifDescr := metrics.oids[oid].ifDescr
-		metricName := metrics.oids[oid].name
-		metrics.prom[metricName].WithLabelValues(metrics.hostname, ifDescr).Add(float64(increase))
+		// A counter that went backwards was reset, as by a reboot of the
+		// switch, so the new value is only a new baseline.
+		increase, ok := counterIncrease(metrics.oids[oid].previousValue, r)
+		if !ok {
+			metrics.log.Info("Counter was reset", "metric", metricName, "oid", oid)
+			metrics.oids[oid] = metricOid
+			continue
+		}
+		metrics.prom[metricName].WithLabelValues(labels...).Add(float64(increase))
+		metricOid.delta = increase
 
 		metricOid.intervalSeries.Samples = append(
 			metricOid.intervalSeries.Samples,
-			archive.Sample{Timestamp: time.Now().Unix(), Value: increase},
+			archive.Sample{Timestamp: now, Value: increase},
 		)
 		metrics.oids[oid] = metricOid
 	}
 
-	if metrics.firstRun == true {
-		metrics.firstRun = false
-	}
-
 	return nil
 }
 
-// Write collects JSON data for all OIDs and then writes the result to an archive.
+// Write writes the samples of each write interval of interval seconds that
+// has ended to an archive of its own, named by the bounds of the interval and
+// placed in the directory of the day it starts on. Normally that is just the
+// interval that ended at the last boundary, whose archive has every series,
+// but if writes were missed, or one ran late, earlier intervals get archives
+// of the series that have samples in them. Samples from the interval in
+// progress stay buffered for the next Write. The buffers are split while
+// holding the lock, and are marshalled and written after releasing it, so
+// that writing never delays a collection.
 func (metrics *Metrics) Write(interval uint64) {
-	var jsonData []byte
+	metrics.write(interval, intervalStart(metrics.clock.Now().Unix(), interval))
+}
 
-	// Set a lock to avoid a race between the collecting and writing of metrics.
-	metrics.mutex.Lock()
-	defer metrics.mutex.Unlock()
+// Flush writes every buffered sample at once, as Write does at the end of an
+// interval. The samples of the interval in progress are archived as the part
+// of the interval up to now, and the archive written when it ends covers only
+// the rest of it.
+func (metrics *Metrics) Flush(interval uint64) {
+	metrics.write(interval, metrics.clock.Now().Unix())
+}
+
+// write writes the samples from before end, which is either the end of an
+// interval or the time of a Flush.
+func (metrics *Metrics) write(interval uint64, end int64) {
+	last := intervalStart(end-1, interval)
 
+	metrics.mutex.Lock()
+	models := map[int64][]archive.Model{last: nil}
 	for oid, values := range metrics.oids {
-		data, err := archive.GetJSON(values.intervalSeries)
-		rtx.Must(err, "Failed to GetJSON for intervalSeries")
-		jsonData = append(jsonData, data...)
+		done, pending := partition(values.intervalSeries.Samples, end, interval)
+		if _, ok := done[last]; !ok {
+			done[last] = []archive.Sample{}
+		}
+		for start, samples := range done {
+			model := values.intervalSeries
+			model.Samples = samples
+			models[start] = append(models[start], model)
+		}
 
 		// This is less than ideal. Because we can't write to a map in a struct
 		// we have to copy the whole map, modify it and then overwrite the
 		// original map. There is likely a better way to do this.
-		metricsOid := metrics.oids[oid]
-		metricsOid.intervalSeries.Samples = []archive.Sample{}
-		metrics.oids[oid] = metricsOid
+		values.intervalSeries.Samples = pending
+		metrics.oids[oid] = values
 	}
+	retired := []archive.Model{}
+	for _, model := range metrics.retired {
+		done, pending := partition(model.Samples, end, interval)
+		for start, samples := range done {
+			m := model
+			m.Samples = samples
+			models[start] = append(models[start], m)
+		}
+		if len(pending) > 0 {
+			model.Samples = pending
+			retired = append(retired, model)
+		}
+	}
+	metrics.retired = retired
+	// An interval that was partly flushed is archived from the flush on.
+	flushed := metrics.flushed
+	metrics.flushed = end
+	metrics.mutex.Unlock()
 
-	archivePath := archive.GetPath(time.Now(), metrics.hostname, interval)
-	err := archive.Write(archivePath, jsonData)
-	if err != nil {
-		rtx.Must(err, "Failed to write archive")
+	starts := make([]int64, 0, len(models))
+	for start := range models {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		from, to := start, start+int64(interval)
+		if flushed > from && flushed < to {
+			from = flushed
+		}
+		if end < to {
+			to = end
+		}
+		if from >= to {
+			continue
+		}
+
+		var jsonData []byte
+		for _, model := range models[start] {
+			data, err := archive.GetJSON(model)
+			rtx.Must(err, "Failed to GetJSON for intervalSeries")
+			jsonData = append(jsonData, data...)
+		}
+
+		archivePath := archive.GetPath(time.Unix(start, 0).UTC(), metrics.hostname, interval)
+		if from != start || to != start+int64(interval) {
+			archivePath = archive.GetRangePath(time.Unix(from, 0).UTC(), time.Unix(to, 0).UTC(), metrics.hostname)
+		}
+		err := archive.Write(archivePath, jsonData)
+		if err != nil {
+			rtx.Must(err, "Failed to write archive")
+		}
+
+		metrics.mutex.Lock()
+		metrics.lastArchive = archivePath
+		metrics.lastArchived = metrics.clock.Now()
+		metrics.mutex.Unlock()
 	}
 }
 
+// intervalStart returns the start of the write interval of interval seconds
+// that timestamp falls in.
+func intervalStart(timestamp int64, interval uint64) int64 {
+	return timestamp - timestamp%int64(interval)
+}
+
+// partition returns the samples from before end, grouped by the start of the
+// write interval they fall in, and the samples from end on, which belong to an
+// interval that has not ended yet.
+func partition(samples []archive.Sample, end int64, interval uint64) (map[int64][]archive.Sample, []archive.Sample) {
+	done := make(map[int64][]archive.Sample)
+	pending := []archive.Sample{}
+	for _, sample := range samples {
+		if sample.Timestamp >= end {
+			pending = append(pending, sample)
+			continue
+		}
+		start := intervalStart(sample.Timestamp, interval)
+		done[start] = append(done[start], sample)
+	}
+	return done, pending
+}
+
 // New creates a new metrics.Metrics struct with various OID maps initialized.
-func New(snmp snmp.SNMP, config config.Config, target string, hostname string) *Metrics {
+// Samples are timestamped, and archives named, by the time according to clk.
+func New(ctx context.Context, snmp snmp.SNMP, c config.Config, target string, hostname string, clk clock.Clock) *Metrics {
 	machine := hostname[:5]
-	ifaces := getIfaces(snmp, machine)
+	ifaces, err := getIfaces(ctx, snmp, machine)
+	rtx.Must(err, "Failed to discover the interfaces of the switch")
 
 	m := &Metrics{
-		oids:     make(map[string]oid),
-		prom:     make(map[string]*prometheus.CounterVec),
-		hostname: hostname,
-		machine:  machine,
-		firstRun: true,
+		oids:      make(map[string]oid),
+		prom:      make(map[string]*prometheus.CounterVec),
+		promGauge: make(map[string]*prometheus.GaugeVec),
+		config:    c,
+		ifaces:    ifaces,
+		hostname:  hostname,
+		machine:   machine,
+		target:    target,
+		clock:     clk,
+		log:       slog.With("target", target),
+		limiter:   logging.NewLimiter(clk, errorLogInterval),
+	}
+
+	for _, metric := range c.Metrics {
+		for oidStr, o := range m.newSeries(metric) {
+			m.oids[oidStr] = o
+		}
+		if metric.Mode == config.Table {
+			m.tables = append(m.tables, metric)
+		}
+		collector := newCollector(metric)
+		prometheus.DefaultRegisterer.MustRegister(collector)
+		m.setCollector(metric.Name, collector)
 	}
 
-	for _, metric := range config.Metrics {
+	return m
+}
+
+// newSeries returns the oids for a metric, keyed by OID. Table rows are
+// discovered on every walk of the table, so series for table metrics are only
+// created once they are first collected.
+func (metrics *Metrics) newSeries(metric config.Metric) map[string]oid {
+	oids := make(map[string]oid)
+
+	switch metric.Mode {
+	case config.Scalar:
+		oids[metric.OidStub] = oid{
+			name:     metric.Name,
+			counter:  metric.IsCounter(),
+			scope:    config.Scalar,
+			labels:   []string{},
+			interval: metric.Interval,
+			intervalSeries: archive.Model{
+				Experiment: metrics.target,
+				Hostname:   metrics.hostname,
+				Metric:     metric.MlabName,
+				Samples:    []archive.Sample{},
+			},
+		}
+	case config.Table:
+	default:
 		discoNames := map[string]string{
 			"machine": metric.MlabMachineName,
 			"uplink":  metric.MlabUplinkName,
 		}
-		for scope, values := range ifaces {
+		for scope, values := range metrics.ifaces {
+			// An interface that wasn't discovered has no series until it is.
+			if values["iface"] == "" {
+				continue
+			}
 			oidStr := createOID(metric.OidStub, values["iface"])
 			o := oid{
-				name:    metric.Name,
-				scope:   scope,
-				ifDescr: values["ifDescr"],
+				name:     metric.Name,
+				counter:  metric.IsCounter(),
+				scope:    scope,
+				ifDescr:  values["ifDescr"],
+				interval: metric.Interval,
 				intervalSeries: archive.Model{
-					Experiment: target,
-					Hostname:   hostname,
+					Experiment: metrics.target,
+					Hostname:   metrics.hostname,
 					Metric:     discoNames[scope],
 					Samples:    []archive.Sample{},
 				},
 			}
-			m.oids[oidStr] = o
+			oids[oidStr] = o
 		}
-		m.prom[metric.Name] = promauto.NewCounterVec(
-			prometheus.CounterOpts{
+	}
+
+	return oids
+}
+
+// collectorLabelNames returns the Prometheus label names of a metric.
+func collectorLabelNames(metric config.Metric) []string {
+	labelNames := []string{"node"}
+	switch metric.Mode {
+	case config.Scalar:
+	case config.Table:
+		labelNames = append(labelNames, tableLabelNames(metric)...)
+	default:
+		labelNames = append(labelNames, "interface")
+	}
+	return labelNames
+}
+
+// newCollector returns an unregistered Prometheus collector for a metric: a
+// CounterVec for counters and a GaugeVec for all other metric types.
+func newCollector(metric config.Metric) prometheus.Collector {
+	labelNames := collectorLabelNames(metric)
+	if !metric.IsCounter() {
+		return prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
 				Name: metric.Name,
 				Help: metric.Description,
 			},
-			[]string{
-				"node",
-				"interface",
-			},
+			labelNames,
 		)
 	}
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metric.Name,
+			Help: metric.Description,
+		},
+		labelNames,
+	)
+}
 
-	return m
+// setCollector records the Prometheus collector for the named metric.
+func (metrics *Metrics) setCollector(name string, collector prometheus.Collector) {
+	switch vec := collector.(type) {
+	case *prometheus.CounterVec:
+		metrics.prom[name] = vec
+	case *prometheus.GaugeVec:
+		metrics.promGauge[name] = vec
+	}
+}
+
+// collector returns the Prometheus collector for the named metric, or nil if
+// there is none.
+func (metrics *Metrics) collector(name string) prometheus.Collector {
+	if vec, ok := metrics.prom[name]; ok {
+		return vec
+	}
+	if vec, ok := metrics.promGauge[name]; ok {
+		return vec
+	}
+	return nil
 }
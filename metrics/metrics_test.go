@@ -1,10 +1,12 @@
 package metrics
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"reflect"
 	"strings"
 	"testing"
@@ -12,8 +14,11 @@ import (
 
 	"github.com/m-lab/go/rtx"
 	"github.com/nkinkade/disco-go/archive"
+	"github.com/nkinkade/disco-go/clock"
 	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/snmp"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/soniah/gosnmp"
 )
 
@@ -77,7 +82,7 @@ var snmpPacketSysUptime = gosnmp.SnmpPacket{
 		{
 			Name:  sysUpTimeOID,
 			Type:  gosnmp.TimeTicks,
-			Value: 1592000258,
+			Value: uint32(1592000258),
 		},
 	},
 }
@@ -132,12 +137,20 @@ var snmpPacketMetricsRun2 = gosnmp.SnmpPacket{
 	},
 }
 
+// mockRealSNMP embeds snmp.SNMP for the operations that collection doesn't use.
 type mockRealSNMP struct {
+	snmp.SNMP
 	err error
 	run int
 }
 
-func (m *mockRealSNMP) BulkWalkAll(rootOid string) (results []gosnmp.SnmpPDU, err error) {
+func (m *mockRealSNMP) BulkWalkAll(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error) {
+	if rootOid == entPhySensorValueOidStub {
+		return sensorRows[m.run], m.err
+	}
+	if rootOid == entPhysicalNameOidStub {
+		return sensorNames, m.err
+	}
 	return []gosnmp.SnmpPDU{
 		{
 			Name:  ifDescrMachineOID,
@@ -152,7 +165,7 @@ func (m *mockRealSNMP) BulkWalkAll(rootOid string) (results []gosnmp.SnmpPDU, er
 	}, nil
 }
 
-func (m *mockRealSNMP) Get(oids []string) (result *gosnmp.SnmpPacket, err error) {
+func (m *mockRealSNMP) Get(ctx context.Context, oids []string) (result *gosnmp.SnmpPacket, err error) {
 	var packet *gosnmp.SnmpPacket
 
 	// len(oids) will only be one when looking up ifDescr.
@@ -191,11 +204,12 @@ func Test_New(t *testing.T) {
 	s := &mockRealSNMP{
 		err: nil,
 	}
-	m := New(s, c, target, hostname)
+	m := New(context.Background(), s, c, target, hostname, clock.Real{})
 
 	var expectedMetricsOIDs = map[string]oid{
 		ifOutDiscardsMachineOID: oid{
 			name:          "ifOutDiscards",
+			counter:       true,
 			previousValue: 0,
 			scope:         "machine",
 			ifDescr:       "xe-0/0/12",
@@ -208,6 +222,7 @@ func Test_New(t *testing.T) {
 		},
 		ifOutDiscardsUplinkOID: oid{
 			name:          "ifOutDiscards",
+			counter:       true,
 			previousValue: 0,
 			scope:         "uplink",
 			ifDescr:       "xe-0/0/45",
@@ -220,6 +235,7 @@ func Test_New(t *testing.T) {
 		},
 		ifHCInOctetsMachineOID: oid{
 			name:          "ifHCInOctets",
+			counter:       true,
 			previousValue: 0,
 			scope:         "machine",
 			ifDescr:       "xe-0/0/12",
@@ -232,6 +248,7 @@ func Test_New(t *testing.T) {
 		},
 		ifHCInOctetsUplinkOID: oid{
 			name:          "ifHCInOctets",
+			counter:       true,
 			previousValue: 0,
 			scope:         "uplink",
 			ifDescr:       "xe-0/0/45",
@@ -256,8 +273,8 @@ func Test_New(t *testing.T) {
 		t.Errorf("Unexpected Metrics.machine.\nGot: %v\nExpected: %v", m.machine, machine)
 	}
 
-	if !m.firstRun {
-		t.Errorf("Metrics.firstRun should be true, but got false.")
+	if m.target != target {
+		t.Errorf("Unexpected Metrics.target.\nGot: %v\nExpected: %v", m.target, target)
 	}
 
 }
@@ -265,23 +282,23 @@ func Test_New(t *testing.T) {
 func Test_Collect(t *testing.T) {
 	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 
-	var expectedValues = map[string]map[string]uint64{
-		ifOutDiscardsMachineOID: map[string]uint64{
+	var expectedValues = map[string]map[string]int64{
+		ifOutDiscardsMachineOID: map[string]int64{
 			"run1Prev":   0,
 			"run2Prev":   0,
 			"run2Sample": 0,
 		},
-		ifOutDiscardsUplinkOID: map[string]uint64{
+		ifOutDiscardsUplinkOID: map[string]int64{
 			"run1Prev":   3,
 			"run2Prev":   8,
 			"run2Sample": 5,
 		},
-		ifHCInOctetsMachineOID: map[string]uint64{
+		ifHCInOctetsMachineOID: map[string]int64{
 			"run1Prev":   275,
 			"run2Prev":   511,
 			"run2Sample": 236,
 		},
-		ifHCInOctetsUplinkOID: map[string]uint64{
+		ifHCInOctetsUplinkOID: map[string]int64{
 			"run1Prev":   437,
 			"run2Prev":   624,
 			"run2Sample": 187,
@@ -292,8 +309,8 @@ func Test_Collect(t *testing.T) {
 		err: nil,
 		run: 1,
 	}
-	m := New(s1, c, target, hostname)
-	m.Collect(s1, c)
+	m := New(context.Background(), s1, c, target, hostname, clock.Real{})
+	m.Collect(context.Background(), s1)
 
 	for oid := range m.oids {
 		// Be sure that previousValues is what we expect.
@@ -311,7 +328,7 @@ func Test_Collect(t *testing.T) {
 		err: nil,
 		run: 2,
 	}
-	m.Collect(s2, c)
+	m.Collect(context.Background(), s2)
 
 	for oid := range m.oids {
 		// Be sure that previousValues is what we expect.
@@ -333,66 +350,692 @@ func Test_Collect(t *testing.T) {
 }
 
 func Test_getOidsInt64BadType(t *testing.T) {
+	var s = &mockRealSNMP{}
+	var oids = []string{ifDescrMachineOID}
+	oidMap, unavailable, err := getOidsInt64(context.Background(), s, oids)
+	if err != nil {
+		t.Errorf("Did not expect an error, but got: %v", err)
+	}
+	if len(oidMap) != 0 {
+		t.Errorf("Expected no values, but got: %v", oidMap)
+	}
+	if unavailable[ifDescrMachineOID] != "UnexpectedType" {
+		t.Errorf("Expected OID %v to be unavailable with reason UnexpectedType, but got: %v", ifDescrMachineOID, unavailable)
+	}
+}
+
+func Test_getOidsInt64TimeTicks(t *testing.T) {
 	var s = &mockRealSNMP{}
 	var oids = []string{sysUpTimeOID}
-	_, err := getOidsInt64(s, oids)
-	if err == nil {
-		t.Error("Expected an error but didn't get one")
+	oidMap, _, err := getOidsInt64(context.Background(), s, oids)
+	if err != nil {
+		t.Errorf("Did not expect an error, but got: %v", err)
+	}
+	if oidMap[sysUpTimeOID].value != 1592000258 {
+		t.Errorf("Expected a value of 1592000258 for OID %v, but got: %v", sysUpTimeOID, oidMap[sysUpTimeOID].value)
 	}
 }
 
 func Test_getOidsInt64NoResults(t *testing.T) {
 	var s = &mockRealSNMP{}
 	var oids = []string{"fake-oid"}
-	_, err := getOidsInt64(s, oids)
+	_, _, err := getOidsInt64(context.Background(), s, oids)
 	if err == nil {
 		t.Errorf("Expected an error but didn't get one")
 	}
 }
 
+// mockAgent answers Gets for the OIDs in values. Other OIDs are answered with
+// NoSuchInstance varbinds, or if v1 is set then the whole request fails with a
+// noSuchName error-status.
+type mockAgent struct {
+	snmp.SNMP
+	values   map[string]uint
+	v1       bool
+	requests int
+}
+
+func (m *mockAgent) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	return nil, nil
+}
+
+func (m *mockAgent) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	m.requests++
+	packet := &gosnmp.SnmpPacket{}
+	for i, oid := range oids {
+		value, ok := m.values[oid]
+		switch {
+		case ok:
+			packet.Variables = append(packet.Variables, gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Counter32, Value: value})
+		case m.v1:
+			return &gosnmp.SnmpPacket{Error: gosnmp.NoSuchName, ErrorIndex: uint8(i + 1)}, nil
+		default:
+			packet.Variables = append(packet.Variables, gosnmp.SnmpPDU{Name: oid, Type: gosnmp.NoSuchInstance})
+		}
+	}
+	return packet, nil
+}
+
+func Test_getOidsInt64Unavailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		v1       bool
+		reason   string
+		requests int
+	}{
+		{
+			name:     "v2c",
+			reason:   "NoSuchInstance",
+			requests: 1,
+		},
+		{
+			name:     "v1",
+			v1:       true,
+			reason:   "NoSuchName",
+			requests: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		s := &mockAgent{
+			values: map[string]uint{
+				ifOutDiscardsMachineOID: 4,
+				ifOutDiscardsUplinkOID:  7,
+			},
+			v1: tt.v1,
+		}
+		oids := []string{ifOutDiscardsMachineOID, ifHCInOctetsMachineOID, ifOutDiscardsUplinkOID, ifHCInOctetsUplinkOID}
+		oidMap, unavailable, err := getOidsInt64(context.Background(), s, oids)
+		if err != nil {
+			t.Fatalf("%v: did not expect an error, but got: %v", tt.name, err)
+		}
+		expectedValues := map[string]reading{
+			ifOutDiscardsMachineOID: {value: 4, typ: gosnmp.Counter32},
+			ifOutDiscardsUplinkOID:  {value: 7, typ: gosnmp.Counter32},
+		}
+		if !reflect.DeepEqual(oidMap, expectedValues) {
+			t.Errorf("%v: unexpected values.\nGot: %v\nExpected: %v", tt.name, oidMap, expectedValues)
+		}
+		expectedUnavailable := map[string]string{ifHCInOctetsMachineOID: tt.reason, ifHCInOctetsUplinkOID: tt.reason}
+		if !reflect.DeepEqual(unavailable, expectedUnavailable) {
+			t.Errorf("%v: unexpected unavailable OIDs.\nGot: %v\nExpected: %v", tt.name, unavailable, expectedUnavailable)
+		}
+		if s.requests != tt.requests {
+			t.Errorf("%v: expected %v requests, but got: %v", tt.name, tt.requests, s.requests)
+		}
+	}
+}
+
+func Test_getOidsInt64ManyOids(t *testing.T) {
+	s := &mockAgent{values: map[string]uint{}, v1: true}
+	oids := []string{}
+	for i := 0; i < 300; i++ {
+		oid := fmt.Sprintf("%v.%v", ifHCInOctetsOidStub, i)
+		oids = append(oids, oid)
+		s.values[oid] = uint(i)
+	}
+	missing := oids[280]
+	delete(s.values, missing)
+
+	oidMap, unavailable, err := getOidsInt64(context.Background(), snmp.NewBatched(s, 0), oids)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if len(oidMap) != len(oids)-1 {
+		t.Errorf("Expected %v values, but got: %v", len(oids)-1, len(oidMap))
+	}
+	for oid, value := range s.values {
+		if oidMap[oid].value != int64(value) {
+			t.Errorf("Expected %v to be %v, but got: %v", oid, value, oidMap[oid].value)
+		}
+	}
+	expectedUnavailable := map[string]string{missing: "NoSuchName"}
+	if !reflect.DeepEqual(unavailable, expectedUnavailable) {
+		t.Errorf("Unexpected unavailable OIDs.\nGot: %v\nExpected: %v", unavailable, expectedUnavailable)
+	}
+}
+
+func Test_CollectUnavailable(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	m := New(context.Background(), &mockRealSNMP{}, c, target, hostname, clock.Real{})
+	s := &mockAgent{
+		values: map[string]uint{
+			ifOutDiscardsMachineOID: 4,
+			ifOutDiscardsUplinkOID:  7,
+		},
+	}
+	before := testutil.ToFloat64(oidsUnavailable.WithLabelValues("ifHCInOctets", ifHCInOctetsMachineOID, "NoSuchInstance"))
+
+	err := m.Collect(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+	if !m.oids[ifOutDiscardsMachineOID].hasPrevious || !m.oids[ifOutDiscardsUplinkOID].hasPrevious {
+		t.Error("Expected the available OIDs to be collected")
+	}
+	if m.oids[ifHCInOctetsMachineOID].hasPrevious {
+		t.Errorf("Did not expect the unavailable OID %v to be collected", ifHCInOctetsMachineOID)
+	}
+	after := testutil.ToFloat64(oidsUnavailable.WithLabelValues("ifHCInOctets", ifHCInOctetsMachineOID, "NoSuchInstance"))
+	if after-before != 1 {
+		t.Errorf("Expected the unavailable OID to be counted once, but got: %v", after-before)
+	}
+}
+
+// counterFixture returns a replay of a switch with the machine and uplink
+// interfaces, whose machine interface has the Counter32 ifOutDiscards and the
+// Counter64 ifHCInOctets.
+func counterFixture(t *testing.T, discards uint32, octets uint64) *snmp.Replay {
+	replay, err := snmp.NewReplay(strings.NewReader(fmt.Sprintf(`
+1.3.6.1.2.1.2.2.1.2.524|4|xe-0/0/12
+1.3.6.1.2.1.2.2.1.2.568|4|xe-0/0/45
+1.3.6.1.2.1.2.2.1.19.524|65|%v
+1.3.6.1.2.1.31.1.1.1.6.524|70|%v
+1.3.6.1.2.1.31.1.1.1.18.524|4|mlab2
+1.3.6.1.2.1.31.1.1.1.18.568|4|uplink-10g
+`, discards, octets)))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+	return replay
+}
+
+func Test_CollectNoUplink(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	replay, err := snmp.NewReplay(strings.NewReader(`
+1.3.6.1.2.1.1.3.0|67|1592000258
+1.3.6.1.2.1.2.2.1.2.524|4|xe-0/0/12
+1.3.6.1.2.1.2.2.1.19.524|65|7
+1.3.6.1.2.1.31.1.1.1.6.524|70|1000
+1.3.6.1.2.1.31.1.1.1.18.524|4|mlab2
+`))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+	mc := config.Config{Metrics: append([]config.Metric{{
+		Name:     "sysUpTime",
+		Type:     config.TimeTicks,
+		Mode:     config.Scalar,
+		OidStub:  sysUpTimeOID,
+		MlabName: "switch.uptime",
+	}}, c.Metrics...)}
+
+	// Only the machine's interface has series, and the rest are collected
+	// without the uplink.
+	m := New(context.Background(), replay, mc, target, hostname, clock.Real{})
+	if len(m.oids) != 3 {
+		t.Errorf("Expected 3 series without an uplink, got %v", m.oids)
+	}
+	if err := m.Collect(context.Background(), replay); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	for _, oid := range []string{sysUpTimeOID, ifOutDiscardsMachineOID, ifHCInOctetsMachineOID} {
+		if !m.oids[oid].hasPrevious {
+			t.Errorf("Expected %v to be collected", oid)
+		}
+	}
+}
+
+func Test_CollectWrapAndReset(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	m := New(context.Background(), counterFixture(t, 0, 0), c, target, hostname, clock.Real{})
+	discards := m.prom["ifOutDiscards"].WithLabelValues(hostname, "xe-0/0/12")
+	octets := m.prom["ifHCInOctets"].WithLabelValues(hostname, "xe-0/0/12")
+
+	// ifOutDiscards wraps around at 2^32, while ifHCInOctets is reset.
+	steps := []struct {
+		discards       uint32
+		octets         uint64
+		discardSamples []int64
+		octetSamples   []int64
+	}{
+		{discards: 4294967290, octets: 5000, discardSamples: []int64{}, octetSamples: []int64{}},
+		{discards: 10, octets: 1000, discardSamples: []int64{16}, octetSamples: []int64{}},
+		{discards: 12, octets: 1500, discardSamples: []int64{16, 2}, octetSamples: []int64{500}},
+	}
+	for i, step := range steps {
+		err := m.Collect(context.Background(), counterFixture(t, step.discards, step.octets))
+		if err != nil {
+			t.Fatalf("Collect() %v error = %v", i, err)
+		}
+		for oid, want := range map[string][]int64{
+			ifOutDiscardsMachineOID: step.discardSamples,
+			ifHCInOctetsMachineOID:  step.octetSamples,
+		} {
+			got := []int64{}
+			for _, sample := range m.oids[oid].intervalSeries.Samples {
+				got = append(got, sample.Value)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("After collection %v samples of %v = %v, want %v", i, oid, got, want)
+			}
+		}
+	}
+	if got := testutil.ToFloat64(discards); got != 18 {
+		t.Errorf("ifOutDiscards = %v, want 18", got)
+	}
+	if got := testutil.ToFloat64(octets); got != 500 {
+		t.Errorf("ifHCInOctets = %v, want 500", got)
+	}
+	if got := m.oids[ifHCInOctetsMachineOID].previousValue; got != 1500 {
+		t.Errorf("ifHCInOctets previous value = %v, want 1500", got)
+	}
+}
+
+func Test_CollectGauge(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	gaugeConfig := config.Config{
+		Metrics: []config.Metric{
+			c.Metrics[0],
+			config.Metric{
+				Name:            "ifOutDiscards",
+				Description:     "Egress discards.",
+				Type:            config.Gauge,
+				OidStub:         ifOutDiscardsOidStub,
+				MlabUplinkName:  "switch.discards.uplink.tx",
+				MlabMachineName: "switch.discards.local.tx",
+			},
+		},
+	}
+
+	var expectedSamples = map[string][]int64{
+		ifOutDiscardsMachineOID: []int64{0, 0},
+		ifOutDiscardsUplinkOID:  []int64{3, 8},
+		ifHCInOctetsMachineOID:  []int64{236},
+		ifHCInOctetsUplinkOID:   []int64{187},
+	}
+
+	s1 := &mockRealSNMP{
+		err: nil,
+		run: 1,
+	}
+	m := New(context.Background(), s1, gaugeConfig, target, hostname, clock.Real{})
+	m.Collect(context.Background(), s1)
+
+	s2 := &mockRealSNMP{
+		err: nil,
+		run: 2,
+	}
+	m.Collect(context.Background(), s2)
+
+	for oid, expected := range expectedSamples {
+		samples := m.oids[oid].intervalSeries.Samples
+		if len(samples) != len(expected) {
+			t.Errorf("For OID %v expected %v samples, but got: %v", oid, len(expected), len(samples))
+			continue
+		}
+		for i, sample := range samples {
+			if sample.Value != expected[i] {
+				t.Errorf("For OID %v expected sample %v to be %v, but got: %v", oid, i, expected[i], sample.Value)
+			}
+		}
+	}
+}
+
 func Test_CollectWithSnmpError(t *testing.T) {
 	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 
 	s := &mockRealSNMP{}
-	m := New(s, c, target, hostname)
+	m := New(context.Background(), s, c, target, hostname, clock.Real{})
 
 	sErr := &mockRealSNMP{
 		err: fmt.Errorf("An SNMP error occured: %s", "error"),
 		run: 1,
 	}
-	err := m.Collect(sErr, c)
+	err := m.Collect(context.Background(), sErr)
 	if err == nil {
 		t.Error("Expected an error but didn't get one")
 	}
 }
 
+// newReplayMetrics returns a replay of the Juniper fixture and new Metrics for
+// the metrics of mc on it, timestamped by clk, registered with a new registry.
+func newReplayMetrics(t *testing.T, mc config.Config, clk clock.Clock) (*snmp.Replay, *Metrics) {
+	t.Helper()
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	replay, err := snmp.LoadReplay("testdata/juniper-qfx5100.snmprec")
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+	return replay, New(context.Background(), replay, mc, target, hostname, clk)
+}
+
+func Test_CollectCancelled(t *testing.T) {
+	replay, m := newReplayMetrics(t, c, clock.Real{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.Collect(ctx, replay)
+	if err != context.Canceled {
+		t.Errorf("Collect() error = %v, want %v", err, context.Canceled)
+	}
+	for oidStr, o := range m.oids {
+		if o.hasPrevious {
+			t.Errorf("Expected nothing to be recorded for %v by a cancelled scrape", oidStr)
+		}
+	}
+}
+
+func Test_CollectDue(t *testing.T) {
+	intervals := config.Config{Metrics: append([]config.Metric{}, c.Metrics...)}
+	intervals.Metrics[1].Interval = time.Minute
+	replay, m := newReplayMetrics(t, intervals, clock.Real{})
+
+	// Polls every 10s from 12:00:10 to 12:01:00, of which only the last is due
+	// for the metric collected every minute.
+	tick := time.Date(2020, 6, 11, 12, 0, 10, 0, time.UTC)
+	for ; tick.Minute() == 0; tick = tick.Add(10 * time.Second) {
+		err := m.CollectDue(context.Background(), replay, tick)
+		if err != nil {
+			t.Fatalf("CollectDue() error = %v", err)
+		}
+		if o := m.oids[ifOutDiscardsOidStub+".524"]; o.hasPrevious {
+			t.Fatalf("ifOutDiscards was collected at %v, before it was due", tick)
+		}
+	}
+	err := m.CollectDue(context.Background(), replay, tick)
+	if err != nil {
+		t.Fatalf("CollectDue() error = %v", err)
+	}
+
+	if o := m.oids[ifOutDiscardsOidStub+".524"]; !o.hasPrevious {
+		t.Errorf("ifOutDiscards was not collected at %v", tick)
+	}
+	// A counter has a sample for every collection after the first.
+	if n := len(m.oids[ifHCInOctetsOidStub+".524"].intervalSeries.Samples); n != 5 {
+		t.Errorf("ifHCInOctets has %v samples, want 5", n)
+	}
+}
+
+// blockingSNMP blocks in Get until released, to simulate a slow switch.
+type blockingSNMP struct {
+	snmp.SNMP
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSNMP) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	b.blocked <- struct{}{}
+	<-b.release
+	return b.SNMP.Get(ctx, oids)
+}
+
+func Test_CollectSingleFlight(t *testing.T) {
+	replay, m := newReplayMetrics(t, c, clock.Real{})
+	m.Collect(context.Background(), replay)
+
+	slow := &blockingSNMP{SNMP: replay, blocked: make(chan struct{}), release: make(chan struct{})}
+	errc := make(chan error)
+	go func() {
+		errc <- m.Collect(context.Background(), slow)
+	}()
+	<-slow.blocked
+
+	overruns := testutil.ToFloat64(collectOverruns)
+	if err := m.Collect(context.Background(), replay); err != ErrCollectionInProgress {
+		t.Errorf("Collect() during another collection error = %v, want %v", err, ErrCollectionInProgress)
+	}
+	if got := testutil.ToFloat64(collectOverruns); got != overruns+1 {
+		t.Errorf("disco_collect_overruns_total = %v, want %v", got, overruns+1)
+	}
+
+	// Writing doesn't wait for the collection blocked on the switch.
+	written := make(chan struct{})
+	go func() {
+		m.Write(10)
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write() was blocked by a collection")
+	}
+	defer os.RemoveAll(fmt.Sprint(time.Now().Year()))
+
+	close(slow.release)
+	if err := <-errc; err != nil {
+		t.Errorf("Collect() error = %v", err)
+	}
+	if n := len(m.oids[ifHCInOctetsOidStub+".524"].intervalSeries.Samples); n != 1 {
+		t.Errorf("Expected the sample of the collection after the Write to be kept, got %v samples", n)
+	}
+	if err := m.Collect(context.Background(), replay); err != nil {
+		t.Errorf("Collect() after the previous collection finished error = %v", err)
+	}
+}
+
 func Test_Write(t *testing.T) {
 	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	defer os.RemoveAll("2020")
 
 	s1 := &mockRealSNMP{
 		err: nil,
 		run: 1,
 	}
-	m := New(s1, c, target, hostname)
-	m.Collect(s1, c)
-
 	s2 := &mockRealSNMP{
 		err: nil,
 		run: 2,
 	}
-	m.Collect(s2, c)
-
-	archivePath := archive.GetPath(time.Now(), hostname, 10)
-	dirPath := path.Dir(archivePath)
+	f := clock.NewFake(time.Date(2020, 6, 11, 23, 59, 50, 0, time.UTC))
+	m := New(context.Background(), s1, c, target, hostname, f)
+	m.Collect(context.Background(), s1)
+	f.Advance(5 * time.Second)
+	m.Collect(context.Background(), s2)
 
+	// A write just after midnight archives the samples from before midnight
+	// in the previous day's directory.
+	f.Set(time.Date(2020, 6, 12, 0, 0, 3, 0, time.UTC))
 	m.Write(10)
-	defer os.RemoveAll(strings.Split(archivePath, "/")[0])
-
+	dirPath := "2020/06/11/" + hostname
 	a, err := ioutil.ReadDir(dirPath)
 	rtx.Must(err, "Could not read test archive directory")
+	if len(a) != 1 || a[0].Name() != "2020-06-11T23:59:50-to-2020-06-12T00:00:00-switch.json" {
+		t.Errorf("Expected one archive of the last interval of the day, but got: %v", a)
+	}
+
+	// When writes are missed, each interval is archived separately, and the
+	// samples of the interval in progress stay buffered.
+	f.Set(time.Date(2020, 6, 12, 0, 0, 5, 0, time.UTC))
+	m.Collect(context.Background(), s2)
+	f.Set(time.Date(2020, 6, 12, 0, 0, 15, 0, time.UTC))
+	m.Collect(context.Background(), s2)
+	f.Set(time.Date(2020, 6, 12, 0, 0, 25, 0, time.UTC))
+	m.Collect(context.Background(), s2)
+	m.Write(10)
+	dirPath = "2020/06/12/" + hostname
+	a, err = ioutil.ReadDir(dirPath)
+	rtx.Must(err, "Could not read test archive directory")
+	if len(a) != 2 {
+		t.Errorf("Expected two archive files, but got: %v", len(a))
+	}
+	for _, model := range readArchive(t, dirPath+"/2020-06-12T00:00:10-to-2020-06-12T00:00:20-switch.json") {
+		if len(model.Samples) != 1 || model.Samples[0].Timestamp != f.Now().Unix()-10 {
+			t.Errorf("Expected the sample from 00:00:15 in %v, got %v", model.Metric, model.Samples)
+		}
+	}
+	if n := len(m.oids[ifHCInOctetsMachineOID].intervalSeries.Samples); n != 1 {
+		t.Errorf("Expected the sample from 00:00:25 to be buffered, got %v samples", n)
+	}
+}
+
+// Test_SimulatedPolling polls and writes archives on a fake clock for hours at
+// a time, with every write running late, to check that samples are archived
+// in the interval they were taken in across day boundaries and changes to and
+// from daylight saving time.
+func Test_SimulatedPolling(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Could not load the America/New_York time zone: %v", err)
+	}
+	tests := []struct {
+		name    string
+		start   time.Time
+		loc     *time.Location
+		archive string
+	}{
+		{
+			name:    "day rollover",
+			start:   time.Date(2020, 6, 11, 23, 0, 0, 0, time.UTC),
+			loc:     time.UTC,
+			archive: "2020/06/11/" + hostname + "/2020-06-11T23:55:00-to-2020-06-12T00:00:00-switch.json",
+		},
+		{
+			name:    "DST ends",
+			start:   time.Date(2020, 11, 1, 5, 0, 0, 0, time.UTC),
+			loc:     newYork,
+			archive: "2020/11/01/" + hostname + "/2020-11-01T05:55:00-to-2020-11-01T06:00:00-switch.json",
+		},
+		{
+			name:    "DST starts",
+			start:   time.Date(2020, 3, 8, 6, 0, 0, 0, time.UTC),
+			loc:     newYork,
+			archive: "2020/03/08/" + hostname + "/2020-03-08T06:55:00-to-2020-03-08T07:00:00-switch.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer os.RemoveAll("2020")
+
+			f := clock.NewFake(tt.start.In(tt.loc))
+			replay, m := newReplayMetrics(t, c, f)
+
+			const writeInterval = 300
+			samples := 0
+			polls := 0
+			end := tt.start.Add(2 * time.Hour)
+			for tick := tt.start.Add(10 * time.Second); !tick.After(end); tick = tick.Add(10 * time.Second) {
+				f.Set(tick.In(tt.loc))
+				err = m.CollectDue(context.Background(), replay, tick)
+				if err != nil {
+					t.Fatalf("CollectDue() at %v error = %v", tick, err)
+				}
+				polls++
+				if tick.Unix()%writeInterval != 0 {
+					continue
+				}
+
+				f.Set(tick.Add(3 * time.Second).In(tt.loc))
+				m.Write(writeInterval)
+				archivePath := archive.GetPath(tick.Add(-writeInterval*time.Second).UTC(), hostname, writeInterval)
+				for _, model := range readArchive(t, archivePath) {
+					if !strings.HasPrefix(model.Metric, "switch.octets.") {
+						continue
+					}
+					for _, s := range model.Samples {
+						if s.Timestamp < tick.Unix()-writeInterval || s.Timestamp >= tick.Unix() {
+							t.Errorf("%v has a sample at %v, outside of its interval", archivePath, time.Unix(s.Timestamp, 0).UTC())
+						}
+						samples++
+					}
+				}
+			}
+			// Each of the two interfaces has a sample for every poll after
+			// the first, except the last, which is still buffered.
+			if want := 2 * (polls - 2); samples != want {
+				t.Errorf("Archived %v ifHCInOctets samples, want %v", samples, want)
+			}
+			if _, err := os.Stat(tt.archive); err != nil {
+				t.Errorf("Expected the archive %v, got: %v", tt.archive, err)
+			}
+		})
+	}
+}
+
+// readArchive reads the models in the archive at archivePath.
+func readArchive(t *testing.T, archivePath string) []archive.Model {
+	data, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Could not read the archive: %v", err)
+	}
+	models := []archive.Model{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		model := archive.Model{}
+		if err := decoder.Decode(&model); err != nil {
+			t.Fatalf("Could not decode %v: %v", archivePath, err)
+		}
+		models = append(models, model)
+	}
+	return models
+}
+
+func Test_Readiness(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s := &mockRealSNMP{
+		err: nil,
+		run: 1,
+	}
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	m := New(context.Background(), s, c, target, hostname, f)
+	if err := m.Discovered(); err != nil {
+		t.Errorf("Discovered() error = %v", err)
+	}
+	if err := m.Collected(30 * time.Second); err == nil {
+		t.Errorf("Expected Collected() to fail before the first collection")
+	}
+
+	m.Collect(context.Background(), s)
+	f.Advance(30 * time.Second)
+	if err := m.Collected(30 * time.Second); err != nil {
+		t.Errorf("Collected() error = %v", err)
+	}
+	f.Advance(time.Second)
+	if err := m.Collected(30 * time.Second); err == nil {
+		t.Errorf("Expected Collected() to fail once the last collection was too long ago")
+	}
+
+	m.ifaces["uplink"]["iface"] = ""
+	if err := m.Discovered(); err == nil || !strings.Contains(err.Error(), "uplink") {
+		t.Errorf("Expected Discovered() to fail without an uplink interface, got: %v", err)
+	}
+}
+
+func Test_Flush(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	defer os.RemoveAll("2020")
+
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	s1 := &mockRealSNMP{run: 1}
+	s2 := &mockRealSNMP{run: 2}
+	m := New(context.Background(), s1, c, target, hostname, f)
+	m.Collect(context.Background(), s1)
+	f.Advance(10 * time.Second)
+	m.Collect(context.Background(), s2)
+
+	// A flush archives the part of the interval so far.
+	f.Set(time.Date(2020, 6, 11, 12, 2, 30, 0, time.UTC))
+	m.Flush(300)
+	flushed := "2020/06/11/" + hostname + "/2020-06-11T12:00:00-to-2020-06-11T12:02:30-switch.json"
+	if got := m.Status().LastArchive; got != flushed {
+		t.Errorf("LastArchive = %v, want %v", got, flushed)
+	}
+	for _, model := range readArchive(t, flushed) {
+		if len(model.Samples) != 1 {
+			t.Errorf("Expected one sample of %v in the flushed archive, got %v", model.Metric, model.Samples)
+		}
+	}
+
+	// The archive at the end of the interval covers the rest of it.
+	f.Set(time.Date(2020, 6, 11, 12, 3, 0, 0, time.UTC))
+	m.Collect(context.Background(), s2)
+	f.Set(time.Date(2020, 6, 11, 12, 5, 0, 0, time.UTC))
+	m.Write(300)
+	rest := "2020/06/11/" + hostname + "/2020-06-11T12:02:30-to-2020-06-11T12:05:00-switch.json"
+	for _, model := range readArchive(t, rest) {
+		if len(model.Samples) != 1 || model.Samples[0].Timestamp != time.Date(2020, 6, 11, 12, 3, 0, 0, time.UTC).Unix() {
+			t.Errorf("Expected the sample from 12:03 of %v in the rest of the interval, got %v", model.Metric, model.Samples)
+		}
+	}
 
-	if len(a) != 1 {
-		t.Errorf("Expected one archive file, but got: %v", len(a))
+	// Later intervals are archived whole again.
+	f.Set(time.Date(2020, 6, 11, 12, 10, 0, 0, time.UTC))
+	m.Write(300)
+	whole := "2020/06/11/" + hostname + "/2020-06-11T12:05:00-to-2020-06-11T12:10:00-switch.json"
+	if got := m.Status().LastArchive; got != whole {
+		t.Errorf("LastArchive = %v, want %v", got, whole)
 	}
-	os.RemoveAll(string(time.Now().Year()))
 }
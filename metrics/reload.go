@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sameSeries reports whether two oids represent the same time series, such
+// that the state of one can be carried over to the other.
+func sameSeries(a, b oid) bool {
+	return a.name == b.name &&
+		a.counter == b.counter &&
+		a.scope == b.scope &&
+		a.ifDescr == b.ifDescr &&
+		reflect.DeepEqual(a.labels, b.labels) &&
+		a.intervalSeries.Metric == b.intervalSeries.Metric
+}
+
+// sameCollector reports whether the Prometheus collector of metric a can be
+// reused for metric b. A registry requires the help string and label names of a
+// metric to stay the same for the lifetime of the process, so a changed
+// description only takes effect on restart.
+func sameCollector(a, b config.Metric) bool {
+	return a.IsCounter() == b.IsCounter() &&
+		reflect.DeepEqual(collectorLabelNames(a), collectorLabelNames(b))
+}
+
+// Reload replaces the metrics configuration with c. The Prometheus collectors
+// of metrics that were added or removed are registered and unregistered
+// accordingly, while the state of series that are unchanged, including their
+// previous values and buffered samples, is preserved. Samples buffered for
+// series that were removed are kept until the next Write. If a new collector
+// cannot be registered, for instance because the type or labels of a metric
+// changed, then the old configuration is kept and an error is returned. A
+// Reload waits for any collection in progress to finish.
+func (metrics *Metrics) Reload(c config.Config) error {
+	metrics.collectMutex.Lock()
+	defer metrics.collectMutex.Unlock()
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	previous := make(map[string]config.Metric)
+	for _, metric := range metrics.config.Metrics {
+		previous[metric.Name] = metric
+	}
+	current := make(map[string]config.Metric)
+	for _, metric := range c.Metrics {
+		current[metric.Name] = metric
+	}
+	unchanged := func(name string) bool {
+		p, ok1 := previous[name]
+		c, ok2 := current[name]
+		return ok1 && ok2 && reflect.DeepEqual(p, c)
+	}
+	keepCollector := func(name string) bool {
+		p, ok1 := previous[name]
+		c, ok2 := current[name]
+		return ok1 && ok2 && sameCollector(p, c)
+	}
+
+	// Collectors are unregistered before new ones are registered, since a
+	// changed metric keeps its name.
+	unregistered := []prometheus.Collector{}
+	for _, metric := range metrics.config.Metrics {
+		if keepCollector(metric.Name) {
+			continue
+		}
+		collector := metrics.collector(metric.Name)
+		prometheus.DefaultRegisterer.Unregister(collector)
+		unregistered = append(unregistered, collector)
+	}
+	registered := make(map[string]prometheus.Collector)
+	for _, metric := range c.Metrics {
+		if keepCollector(metric.Name) {
+			continue
+		}
+		collector := newCollector(metric)
+		err := prometheus.DefaultRegisterer.Register(collector)
+		if err != nil {
+			for _, r := range registered {
+				prometheus.DefaultRegisterer.Unregister(r)
+			}
+			for _, u := range unregistered {
+				prometheus.DefaultRegisterer.MustRegister(u)
+			}
+			return fmt.Errorf("failed to register metric '%v': %v", metric.Name, err)
+		}
+		registered[metric.Name] = collector
+	}
+
+	for _, metric := range metrics.config.Metrics {
+		switch {
+		case !keepCollector(metric.Name):
+			delete(metrics.prom, metric.Name)
+			delete(metrics.promGauge, metric.Name)
+		case !unchanged(metric.Name):
+			// The series of a changed metric may differ, so stale ones are
+			// dropped from its collector.
+			if vec, ok := metrics.prom[metric.Name]; ok {
+				vec.Reset()
+			}
+			if vec, ok := metrics.promGauge[metric.Name]; ok {
+				vec.Reset()
+			}
+		}
+	}
+	for name, collector := range registered {
+		metrics.setCollector(name, collector)
+	}
+	metrics.replaceSeries(c, unchanged)
+	metrics.config = c
+
+	return nil
+}
+
+// Rediscover finds the machine and uplink interfaces of the switch again, as
+// after it was recabled, and replaces the series of interface metrics if they
+// moved, keeping the state of those that didn't. Samples buffered for series
+// that were replaced are kept until the next Write. If the interfaces cannot be
+// discovered then the old ones are kept and an error is returned. Like Reload,
+// Rediscover waits for any collection in progress to finish, and no collection
+// starts until it is done, so that it never queries the switch at the same
+// time as a collection.
+func (metrics *Metrics) Rediscover(ctx context.Context, snmp snmp.SNMP) error {
+	metrics.collectMutex.Lock()
+	defer metrics.collectMutex.Unlock()
+	ifaces, err := getIfaces(ctx, snmp, metrics.machine)
+	if err != nil {
+		return err
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	previous := metrics.oids
+	metrics.ifaces = ifaces
+	metrics.replaceSeries(metrics.config, func(string) bool { return true })
+
+	// Series of interfaces that moved are dropped from their collectors, so
+	// that Prometheus stops seeing their last values.
+	for oidStr, prev := range previous {
+		if o, ok := metrics.oids[oidStr]; ok && sameSeries(prev, o) {
+			continue
+		}
+		metrics.deleteSeries(prev)
+	}
+	return nil
+}
+
+// deleteSeries drops the series of o from its collector.
+func (metrics *Metrics) deleteSeries(o oid) {
+	labels := o.labelValues(metrics.hostname)
+	if vec, ok := metrics.prom[o.name]; ok {
+		vec.DeleteLabelValues(labels...)
+	}
+	if vec, ok := metrics.promGauge[o.name]; ok {
+		vec.DeleteLabelValues(labels...)
+	}
+}
+
+// replaceSeries replaces the series with those of the metrics of c, carrying
+// over the state of series that are the same, and the rows of the tables for
+// which unchanged is true. The unwritten samples of series that are dropped
+// are retired. metrics.mutex must be held.
+func (metrics *Metrics) replaceSeries(c config.Config, unchanged func(name string) bool) {
+	oids := make(map[string]oid)
+	kept := make(map[string]bool)
+	tables := []config.Metric{}
+	for _, metric := range c.Metrics {
+		for oidStr, o := range metrics.newSeries(metric) {
+			if prev, ok := metrics.oids[oidStr]; ok && sameSeries(prev, o) {
+				prev.interval = o.interval
+				o = prev
+				kept[oidStr] = true
+			}
+			oids[oidStr] = o
+		}
+		if metric.Mode == config.Table {
+			tables = append(tables, metric)
+			if !unchanged(metric.Name) {
+				continue
+			}
+			for oidStr, prev := range metrics.oids {
+				if prev.scope == config.Table && prev.name == metric.Name {
+					oids[oidStr] = prev
+					kept[oidStr] = true
+				}
+			}
+		}
+	}
+	for oidStr, prev := range metrics.oids {
+		if !kept[oidStr] && len(prev.intervalSeries.Samples) > 0 {
+			metrics.retired = append(metrics.retired, prev.intervalSeries)
+		}
+	}
+
+	metrics.oids = oids
+	metrics.tables = tables
+}
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soniah/gosnmp"
+)
+
+func Test_ReloadUnchanged(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s1 := &mockRealSNMP{run: 1}
+	m := New(context.Background(), s1, c, target, hostname, clock.Real{})
+	m.Collect(context.Background(), s1)
+	s2 := &mockRealSNMP{run: 2}
+	m.Collect(context.Background(), s2)
+
+	before := make(map[string]oid)
+	for k, v := range m.oids {
+		before[k] = v
+	}
+
+	err := m.Reload(c)
+	if err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(m.oids, before) {
+		t.Errorf("Expected Reload() with the same config to preserve all series.\nGot: %v\nExpected: %v", m.oids, before)
+	}
+	if len(m.retired) != 0 {
+		t.Errorf("Expected no retired series, but got: %v", len(m.retired))
+	}
+}
+
+func Test_ReloadChanged(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s1 := &mockRealSNMP{run: 1}
+	m := New(context.Background(), s1, c, target, hostname, clock.Real{})
+	m.Collect(context.Background(), s1)
+	s2 := &mockRealSNMP{run: 2}
+	m.Collect(context.Background(), s2)
+
+	// ifHCInOctets only changes its description, so its series are kept, while
+	// ifOutDiscards is removed and sysUpTime is added.
+	inOctets := c.Metrics[0]
+	inOctets.Description = "Ingress octets, changed."
+	newConfig := config.Config{
+		Metrics: []config.Metric{
+			inOctets,
+			config.Metric{
+				Name:        "sysUpTime",
+				Description: "Time since the agent was started.",
+				Type:        config.TimeTicks,
+				Mode:        config.Scalar,
+				OidStub:     sysUpTimeOID,
+				MlabName:    "switch.uptime",
+			},
+		},
+	}
+
+	err := m.Reload(newConfig)
+	if err != nil {
+		t.Fatalf("Reload() returned an unexpected error: %v", err)
+	}
+
+	if len(m.oids) != 3 {
+		t.Errorf("Expected 3 series after Reload(), but got: %v", len(m.oids))
+	}
+	for _, oidStr := range []string{ifHCInOctetsMachineOID, ifHCInOctetsUplinkOID} {
+		o, ok := m.oids[oidStr]
+		if !ok {
+			t.Errorf("Expected OID %v to be kept after Reload()", oidStr)
+			continue
+		}
+		if !o.hasPrevious || len(o.intervalSeries.Samples) != 1 {
+			t.Errorf("Expected the state of OID %v to be kept after Reload(), but got: %v", oidStr, o)
+		}
+	}
+	if _, ok := m.oids[sysUpTimeOID]; !ok {
+		t.Errorf("Expected OID %v to be added by Reload()", sysUpTimeOID)
+	}
+	for _, oidStr := range []string{ifOutDiscardsMachineOID, ifOutDiscardsUplinkOID} {
+		if _, ok := m.oids[oidStr]; ok {
+			t.Errorf("Expected OID %v to be removed by Reload()", oidStr)
+		}
+	}
+	if len(m.retired) != 2 {
+		t.Errorf("Expected the 2 removed series to be retired, but got: %v", len(m.retired))
+	}
+	if m.collector("ifOutDiscards") != nil {
+		t.Error("Expected the ifOutDiscards collector to be removed by Reload()")
+	}
+	if m.collector("sysUpTime") == nil {
+		t.Error("Expected a sysUpTime collector to be added by Reload()")
+	}
+}
+
+func Test_ReloadRegisterError(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s := &mockRealSNMP{}
+	m := New(context.Background(), s, c, target, hostname, clock.Real{})
+
+	// A collector registered outside of Metrics conflicts with the new one.
+	prometheus.DefaultRegisterer.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "conflict",
+		Help: "A conflicting metric.",
+	}))
+	newConfig := config.Config{
+		Metrics: append([]config.Metric{}, c.Metrics...),
+	}
+	newConfig.Metrics[1].Type = config.Gauge
+	newConfig.Metrics = append(newConfig.Metrics, config.Metric{
+		Name:        "conflict",
+		Description: "Conflicts with an existing metric.",
+		Type:        config.Gauge,
+		Mode:        config.Scalar,
+		OidStub:     sysUpTimeOID,
+		MlabName:    "switch.conflict",
+	})
+
+	err := m.Reload(newConfig)
+	if err == nil {
+		t.Fatal("Expected Reload() to return an error, but it didn't")
+	}
+	if !reflect.DeepEqual(m.config, c) {
+		t.Errorf("Expected the old config to be kept after a failed Reload(), but got: %v", m.config)
+	}
+	if len(m.oids) != 4 {
+		t.Errorf("Expected the 4 old series to be kept after a failed Reload(), but got: %v", len(m.oids))
+	}
+	// The collector unregistered for ifOutDiscards, whose type changed, must
+	// have been registered again.
+	err = prometheus.DefaultRegisterer.Register(m.collector("ifOutDiscards"))
+	if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+		t.Errorf("Expected the ifOutDiscards collector to still be registered, but got: %v", err)
+	}
+}
+
+// recabledSNMP is a switch whose machine and uplink have swapped ports since
+// mockRealSNMP, or whose ifAlias walk fails if err is set.
+type recabledSNMP struct {
+	mockRealSNMP
+}
+
+func (r *recabledSNMP) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []gosnmp.SnmpPDU{
+		{Name: ifDescrMachineOID, Type: gosnmp.OctetString, Value: []byte("uplink-10g")},
+		{Name: ifDescrUplinkOID, Type: gosnmp.OctetString, Value: []byte("mlab2")},
+	}, nil
+}
+
+func Test_Rediscover(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s1 := &mockRealSNMP{run: 1}
+	m := New(context.Background(), s1, c, target, hostname, clock.Real{})
+	m.Collect(context.Background(), s1)
+	s2 := &mockRealSNMP{run: 2}
+	m.Collect(context.Background(), s2)
+
+	err := m.Rediscover(context.Background(), &recabledSNMP{mockRealSNMP{err: errors.New("timeout")}})
+	if err == nil {
+		t.Errorf("Expected Rediscover() to fail when the walk fails")
+	}
+	if m.ifaces["machine"]["iface"] != "524" {
+		t.Errorf("Expected a failed Rediscover() to keep the interfaces, got %v", m.ifaces)
+	}
+
+	err = m.Rediscover(context.Background(), &recabledSNMP{})
+	if err != nil {
+		t.Fatalf("Rediscover() error = %v", err)
+	}
+	if m.ifaces["machine"]["iface"] != "568" || m.ifaces["machine"]["ifDescr"] != "xe-0/0/45" {
+		t.Errorf("Expected the machine interface to move to 568, got %v", m.ifaces["machine"])
+	}
+	o := m.oids[ifHCInOctetsUplinkOID]
+	if o.scope != "machine" || o.hasPrevious {
+		t.Errorf("Expected a new machine series for %v, got %+v", ifHCInOctetsUplinkOID, o)
+	}
+	// The samples of the series that moved are kept for the next Write.
+	if len(m.retired) != 4 {
+		t.Errorf("Expected 4 retired series, got %v", len(m.retired))
+	}
+}
+
+// queriedSNMP closes queried when the switch is first walked.
+type queriedSNMP struct {
+	snmp.SNMP
+	once    sync.Once
+	queried chan struct{}
+}
+
+func (q *queriedSNMP) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	q.once.Do(func() { close(q.queried) })
+	return q.SNMP.BulkWalkAll(ctx, rootOid)
+}
+
+func Test_RediscoverWaitsForCollection(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s1 := &mockRealSNMP{run: 1}
+	m := New(context.Background(), s1, c, target, hostname, clock.Real{})
+	slow := &blockingSNMP{SNMP: s1, blocked: make(chan struct{}), release: make(chan struct{})}
+	collected := make(chan error)
+	go func() {
+		collected <- m.Collect(context.Background(), slow)
+	}()
+	<-slow.blocked
+
+	// Rediscover doesn't query the switch while the collection is using it.
+	recabled := &queriedSNMP{SNMP: &recabledSNMP{}, queried: make(chan struct{})}
+	rediscovered := make(chan error)
+	go func() {
+		rediscovered <- m.Rediscover(context.Background(), recabled)
+	}()
+	select {
+	case <-recabled.queried:
+		t.Fatal("Rediscover() queried the switch during a collection")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(slow.release)
+	if err := <-collected; err != nil {
+		t.Errorf("Collect() error = %v", err)
+	}
+	if err := <-rediscovered; err != nil {
+		t.Errorf("Rediscover() error = %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Test_Replay runs collection against synthetic fixtures, written by hand to
+// follow the interface layout of each vendor's switches, to catch regressions
+// in how those layouts are handled. Where a case has updates, the fixture is
+// collected a second time with those lines replaced, and the increases
+// recorded by that collection are checked.
+func Test_Replay(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		ifaces      map[string]map[string]string
+		values      map[string]int64
+		unavailable []string
+		updates     map[string]string
+		increases   map[string]int64
+	}{
+		{
+			fixture: "testdata/juniper-qfx5100.snmprec",
+			ifaces: map[string]map[string]string{
+				"machine": {"iface": "524", "ifDescr": "xe-0/0/12"},
+				"uplink":  {"iface": "568", "ifDescr": "xe-0/0/45"},
+			},
+			values: map[string]int64{
+				ifHCInOctetsOidStub + ".524":  918273645546,
+				ifHCInOctetsOidStub + ".568":  1234567890123,
+				ifOutDiscardsOidStub + ".524": 17,
+				ifOutDiscardsOidStub + ".568": 3,
+			},
+		},
+		{
+			fixture: "testdata/cisco-nexus3064.snmprec",
+			ifaces: map[string]map[string]string{
+				"machine": {"iface": "436207616", "ifDescr": "Ethernet1/1"},
+				"uplink":  {"iface": "436404224", "ifDescr": "Ethernet1/49"},
+			},
+			values: map[string]int64{
+				ifHCInOctetsOidStub + ".436207616":  72057594037927936,
+				ifHCInOctetsOidStub + ".436404224":  9876543210,
+				ifOutDiscardsOidStub + ".436207616": 8,
+				ifOutDiscardsOidStub + ".436404224": 4294967290,
+			},
+			// The uplink's ifOutDiscards is a Counter32 about to wrap around.
+			updates: map[string]string{
+				"1.3.6.1.2.1.2.2.1.19.436404224|65|4294967290": "1.3.6.1.2.1.2.2.1.19.436404224|65|5",
+			},
+			increases: map[string]int64{
+				ifOutDiscardsOidStub + ".436404224": 11,
+			},
+		},
+		{
+			fixture: "testdata/arista-7050.snmprec",
+			ifaces: map[string]map[string]string{
+				"machine": {"iface": "1", "ifDescr": "Ethernet1"},
+				"uplink":  {"iface": "49001", "ifDescr": "Ethernet49/1"},
+			},
+			values: map[string]int64{
+				ifHCInOctetsOidStub + ".1":      4400,
+				ifHCInOctetsOidStub + ".49001":  8800,
+				ifOutDiscardsOidStub + ".49001": 12,
+			},
+			unavailable: []string{ifOutDiscardsOidStub + ".1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			prometheus.DefaultRegisterer = prometheus.NewRegistry()
+			oidsUnavailable.Reset()
+
+			replay, err := snmp.LoadReplay(tt.fixture)
+			if err != nil {
+				t.Fatalf("LoadReplay() error = %v", err)
+			}
+			m := New(context.Background(), replay, c, target, hostname, clock.Real{})
+			for scope, want := range tt.ifaces {
+				for k, v := range want {
+					if m.ifaces[scope][k] != v {
+						t.Errorf("%v %v = %q, want %q", scope, k, m.ifaces[scope][k], v)
+					}
+				}
+			}
+
+			err = m.Collect(context.Background(), replay)
+			if err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+			for oid, want := range tt.values {
+				o, ok := m.oids[oid]
+				if !ok || !o.hasPrevious || o.previousValue != want {
+					t.Errorf("value of %v = %v, want %v", oid, o.previousValue, want)
+				}
+			}
+			for _, oid := range tt.unavailable {
+				count := testutil.ToFloat64(oidsUnavailable.WithLabelValues(m.oids[oid].name, oid, "NoSuchObject"))
+				if count != 1 {
+					t.Errorf("unavailable count of %v = %v, want 1", oid, count)
+				}
+			}
+			if tt.updates == nil {
+				return
+			}
+
+			b, err := os.ReadFile(tt.fixture)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			fixture := string(b)
+			for old, updated := range tt.updates {
+				if !strings.Contains(fixture, old) {
+					t.Fatalf("%v has no line %q", tt.fixture, old)
+				}
+				fixture = strings.Replace(fixture, old, updated, 1)
+			}
+			updated, err := snmp.NewReplay(strings.NewReader(fixture))
+			if err != nil {
+				t.Fatalf("NewReplay() error = %v", err)
+			}
+			err = m.Collect(context.Background(), updated)
+			if err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+			for oid, want := range tt.increases {
+				samples := m.oids[oid].intervalSeries.Samples
+				if len(samples) == 0 || samples[len(samples)-1].Value != want {
+					t.Errorf("samples of %v = %v, want last %v", oid, samples, want)
+				}
+				counter := m.prom[m.oids[oid].name].WithLabelValues(m.oids[oid].labelValues(hostname)...)
+				if got := testutil.ToFloat64(counter); got != float64(want) {
+					t.Errorf("counter of %v = %v, want %v", oid, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_Subtrees(t *testing.T) {
+	both := config.Config{Metrics: append(append([]config.Metric{}, c.Metrics...), tableConfig.Metrics...)}
+	want := []string{
+		ifAliasOid, ifDescrOidStub, ifHCInOctetsOidStub, ifOutDiscardsOidStub,
+		entPhySensorValueOidStub, entPhysicalNameOidStub,
+	}
+	if got := Subtrees(both); !reflect.DeepEqual(got, want) {
+		t.Errorf("Subtrees() = %v, want %v", got, want)
+	}
+}
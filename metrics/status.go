@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxErrors is the number of recent errors kept for Status.
+const maxErrors = 20
+
+// errorLogInterval is how often the same error is logged. An unreachable
+// switch fails every poll, so repeats in between are only counted.
+const errorLogInterval = time.Minute
+
+// Error is an error that occurred at Time.
+type Error struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Interface is an interface of the switch that metrics are collected for.
+type Interface struct {
+	IfIndex string `json:"ifIndex"`
+	IfDescr string `json:"ifDescr"`
+	IfAlias string `json:"ifAlias"`
+}
+
+// Series is the state of a single time series. Value is the last value that
+// was collected, and for counters Delta is the last increase. Buffered is the
+// number of samples waiting to be archived.
+type Series struct {
+	Metric    string            `json:"metric"`
+	OID       string            `json:"oid"`
+	Scope     string            `json:"scope"`
+	Interface string            `json:"interface,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Counter   bool              `json:"counter"`
+	Collected bool              `json:"collected"`
+	Value     int64             `json:"value"`
+	Delta     int64             `json:"delta"`
+	Buffered  int               `json:"buffered"`
+}
+
+// Status is a snapshot of the state of a Metrics, for debugging.
+type Status struct {
+	Target        string               `json:"target"`
+	Hostname      string               `json:"hostname"`
+	Config        string               `json:"config"`
+	Interfaces    map[string]Interface `json:"interfaces"`
+	Series        []Series             `json:"series"`
+	Retired       int                  `json:"retired"`
+	LastCollected time.Time            `json:"lastCollected"`
+	LastArchive   string               `json:"lastArchive"`
+	LastArchived  time.Time            `json:"lastArchived"`
+	Errors        []Error              `json:"errors"`
+}
+
+// Status returns a snapshot of the state of the metrics: the configuration, as
+// YAML with OIDs resolved, the interfaces found on the switch, the series with
+// their last values, the number of samples buffered for retired series, the
+// last archive written and the most recent errors.
+func (metrics *Metrics) Status() Status {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	config := &bytes.Buffer{}
+	if err := metrics.config.Print(config); err != nil {
+		fmt.Fprintf(config, "# %v\n", err)
+	}
+	status := Status{
+		Target:        metrics.target,
+		Hostname:      metrics.hostname,
+		Config:        config.String(),
+		Interfaces:    make(map[string]Interface),
+		Series:        []Series{},
+		LastCollected: metrics.collected,
+		LastArchive:   metrics.lastArchive,
+		LastArchived:  metrics.lastArchived,
+		Errors:        append([]Error{}, metrics.recentErrors...),
+	}
+	for scope, values := range metrics.ifaces {
+		status.Interfaces[scope] = Interface{
+			IfIndex: values["iface"],
+			IfDescr: values["ifDescr"],
+			IfAlias: values["ifAlias"],
+		}
+	}
+	for oidStr, o := range metrics.oids {
+		status.Series = append(status.Series, Series{
+			Metric:    o.name,
+			OID:       oidStr,
+			Scope:     o.scope,
+			Interface: o.ifDescr,
+			Labels:    o.intervalSeries.Labels,
+			Counter:   o.counter,
+			Collected: o.hasPrevious,
+			Value:     o.previousValue,
+			Delta:     o.delta,
+			Buffered:  len(o.intervalSeries.Samples),
+		})
+	}
+	sort.Slice(status.Series, func(i, j int) bool {
+		a, b := status.Series[i], status.Series[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.OID < b.OID
+	})
+	for _, model := range metrics.retired {
+		status.Retired += len(model.Samples)
+	}
+	return status
+}
+
+// logError logs msg as an error with err and attrs, as logLimited does, and
+// keeps it among the recent errors of Status.
+func (metrics *Metrics) logError(msg string, err error, attrs ...interface{}) {
+	metrics.logLimited(slog.LevelError, msg, err, attrs...)
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	message := fmt.Sprintf("%v: %v", msg, err)
+	metrics.recentErrors = append(metrics.recentErrors, Error{Time: metrics.clock.Now(), Message: message})
+	if len(metrics.recentErrors) > maxErrors {
+		metrics.recentErrors = metrics.recentErrors[len(metrics.recentErrors)-maxErrors:]
+	}
+}
+
+// logLimited logs msg at level with err and attrs. Messages are logged at most
+// once per errorLogInterval, with the number of repeats that were not, for
+// each combination of msg, the metric, oid and scope attrs, and err, so that
+// a new problem is logged even while another with the same msg is suppressed.
+func (metrics *Metrics) logLimited(level slog.Level, msg string, err error, attrs ...interface{}) {
+	key := []string{msg, err.Error()}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		switch attrs[i] {
+		case "metric", "oid", "scope":
+			key = append(key, fmt.Sprintf("%v=%v", attrs[i], attrs[i+1]))
+		}
+	}
+	if ok, suppressed := metrics.limiter.Allow(strings.Join(key, "\x00")); ok {
+		if suppressed > 0 {
+			attrs = append(attrs, "suppressed", suppressed)
+		}
+		metrics.log.Log(context.Background(), level, msg, append(attrs, "error", err)...)
+	}
+}
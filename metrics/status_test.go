@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_Status(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	s1 := &mockRealSNMP{run: 1}
+	m := New(context.Background(), s1, c, target, hostname, f)
+	m.Collect(context.Background(), s1)
+	f.Advance(10 * time.Second)
+	s2 := &mockRealSNMP{run: 2}
+	m.Collect(context.Background(), s2)
+	m.Collect(context.Background(), &mockRealSNMP{err: errors.New("timeout")})
+
+	status := m.Status()
+	if status.Target != target || status.Hostname != hostname {
+		t.Errorf("Status() target and hostname = %v, %v", status.Target, status.Hostname)
+	}
+	if !strings.Contains(status.Config, "name: ifHCInOctets") {
+		t.Errorf("Expected the config in the status, got %v", status.Config)
+	}
+	want := Interface{IfIndex: "524", IfDescr: "xe-0/0/12", IfAlias: "mlab2"}
+	if got := status.Interfaces["machine"]; got != want {
+		t.Errorf("Machine interface = %+v, want %+v", got, want)
+	}
+	if got := status.Interfaces["uplink"].IfAlias; got != "uplink-10g" {
+		t.Errorf("Uplink ifAlias = %v, want uplink-10g", got)
+	}
+	if len(status.Series) != 4 {
+		t.Fatalf("Expected 4 series, got %v", len(status.Series))
+	}
+	for _, series := range status.Series {
+		o := m.oids[series.OID]
+		if !series.Collected || series.Value != o.previousValue || series.Buffered != 1 {
+			t.Errorf("Series %+v does not match its state %+v", series, o)
+		}
+		if series.Delta != o.intervalSeries.Samples[0].Value {
+			t.Errorf("Series %v delta = %v, want %v", series.OID, series.Delta, o.intervalSeries.Samples[0].Value)
+		}
+	}
+	if !status.LastCollected.Equal(f.Now()) {
+		t.Errorf("LastCollected = %v, want %v", status.LastCollected, f.Now())
+	}
+	if len(status.Errors) != 1 || !strings.Contains(status.Errors[0].Message, "timeout") {
+		t.Errorf("Expected the failed collection in the errors, got %v", status.Errors)
+	}
+
+	for i := 0; i < maxErrors; i++ {
+		m.logError("Failed", fmt.Errorf("error %v", i))
+	}
+	if errs := m.Status().Errors; len(errs) != maxErrors || errs[0].Message != "Failed: error 0" {
+		t.Errorf("Expected only the %v most recent errors, got %v", maxErrors, errs)
+	}
+}
+
+func Test_LogErrorLimited(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+	out := &bytes.Buffer{}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(out, nil)))
+
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	m := New(context.Background(), &mockRealSNMP{}, c, target, hostname, f)
+	for i := 0; i < 7; i++ {
+		m.logError("Failed to GET OIDs from the SNMP server", errors.New("request timeout"))
+		f.Advance(10 * time.Second)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the error to be logged twice in a minute, got %q", lines)
+	}
+	if !strings.Contains(lines[0], `"target":"`+target+`"`) || !strings.Contains(lines[0], `"level":"ERROR"`) {
+		t.Errorf("Expected an error with the target, got %v", lines[0])
+	}
+	if !strings.Contains(lines[1], `"suppressed":5`) {
+		t.Errorf("Expected the suppressed errors to be counted, got %v", lines[1])
+	}
+	if errs := m.Status().Errors; len(errs) != 7 {
+		t.Errorf("Expected every error in the status, got %v", errs)
+	}
+}
+
+func Test_LogErrorDistinct(t *testing.T) {
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+	out := &bytes.Buffer{}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(out, nil)))
+
+	// The uplink has neither ifOutDiscards nor ifHCInOctets in the fixture, so
+	// both of its OIDs fail in the same collection.
+	f := clock.NewFake(time.Date(2020, 6, 11, 12, 0, 0, 0, time.UTC))
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	replay := counterFixture(t, 1, 1)
+	m := New(context.Background(), replay, c, target, hostname, f)
+	m.Collect(context.Background(), replay)
+	f.Advance(10 * time.Second)
+	m.Collect(context.Background(), replay)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected each unavailable OID to be logged once, got %q", lines)
+	}
+	for _, oid := range []string{ifOutDiscardsUplinkOID, ifHCInOctetsUplinkOID} {
+		found := false
+		for _, line := range lines {
+			if strings.Contains(line, `"oid":"`+oid+`"`) {
+				found = true
+				if !strings.Contains(line, `"scope":"uplink"`) || !strings.Contains(line, `"level":"WARN"`) {
+					t.Errorf("Expected a warning with the uplink scope, got %v", line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected unavailable OID %v to be logged, got %q", oid, lines)
+		}
+	}
+
+	// The same call failing with another error is logged too.
+	out.Reset()
+	m.logError("Failed to walk a table from the SNMP server", errors.New("request timeout"), "metric", "entPhySensorValue")
+	m.logError("Failed to walk a table from the SNMP server", errors.New("request timeout"), "metric", "entPhySensorValue")
+	m.logError("Failed to walk a table from the SNMP server", errors.New("connection refused"), "metric", "entPhySensorValue")
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 {
+		t.Errorf("Expected each distinct error to be logged once, got %q", lines)
+	}
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nkinkade/disco-go/archive"
+	"github.com/nkinkade/disco-go/config"
+	"github.com/nkinkade/disco-go/snmp"
+)
+
+// tableLabelNames returns the Prometheus label names for the rows of a table
+// metric, excluding the "node" label: the index label followed by the names of
+// any label OIDs in sorted order.
+func tableLabelNames(metric config.Metric) []string {
+	names := []string{}
+	for name := range metric.LabelOids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{metric.IndexLabel}, names...)
+}
+
+// walkTable walks every row of a table metric, adding the value of each row to
+// oidValueMap and marking it in seen. Rows whose value is not int-type are
+// counted as unavailable and skipped. A new oid is added to newRows for any
+// row that is not in known, the existing series keyed by OID. The
+// labels of new rows are looked up by walking each label OID of the metric once,
+// rather than with a request per row.
+func (metrics *Metrics) walkTable(ctx context.Context, snmp snmp.SNMP, metric config.Metric, known map[string]oid, seen map[string]bool, newRows map[string]oid, oidValueMap map[string]reading) error {
+	pdus, err := snmp.BulkWalkAll(ctx, metric.OidStub)
+	if err != nil {
+		return err
+	}
+
+	var columns map[string]map[string]string
+	for _, pdu := range pdus {
+		seen[pdu.Name] = true
+		value, err := pduInt64(pdu)
+		if err != nil {
+			metrics.recordUnavailable(metric.Name, pdu.Name, config.Table, pduReason(pdu))
+			continue
+		}
+		_, ok := known[pdu.Name]
+		if _, added := newRows[pdu.Name]; !ok && !added {
+			if columns == nil {
+				columns, err = walkLabelOids(ctx, snmp, metric)
+				if err != nil {
+					return err
+				}
+			}
+			index := strings.TrimPrefix(strings.TrimPrefix(pdu.Name, metric.OidStub), ".")
+			newRows[pdu.Name] = metrics.newTableOid(metric, index, columns)
+		}
+		oidValueMap[pdu.Name] = reading{value: value, typ: pdu.Type}
+	}
+
+	return nil
+}
+
+// walkLabelOids walks each label OID of a table metric, returning the values
+// of each label keyed by its name and then by the index of the row.
+func walkLabelOids(ctx context.Context, snmp snmp.SNMP, metric config.Metric) (map[string]map[string]string, error) {
+	columns := make(map[string]map[string]string)
+	for name, stub := range metric.LabelOids {
+		pdus, err := snmp.BulkWalkAll(ctx, stub)
+		if err != nil {
+			return nil, err
+		}
+		column := make(map[string]string)
+		for _, pdu := range pdus {
+			index := strings.TrimPrefix(strings.TrimPrefix(pdu.Name, stub), ".")
+			switch value := pdu.Value.(type) {
+			case []byte:
+				column[index] = strings.TrimSpace(string(value))
+			default:
+				column[index] = strings.TrimSpace(fmt.Sprint(value))
+			}
+		}
+		columns[name] = column
+	}
+	return columns, nil
+}
+
+// newTableOid creates an oid for the row of a table metric with the given
+// index, taking its labels from columns, as returned by walkLabelOids.
+func (metrics *Metrics) newTableOid(metric config.Metric, index string, columns map[string]map[string]string) oid {
+	labelNames := tableLabelNames(metric)
+	labels := map[string]string{metric.IndexLabel: index}
+	for name := range metric.LabelOids {
+		labels[name] = columns[name][index]
+	}
+
+	labelValues := []string{}
+	for _, name := range labelNames {
+		labelValues = append(labelValues, labels[name])
+	}
+
+	return oid{
+		name:     metric.Name,
+		counter:  metric.IsCounter(),
+		scope:    config.Table,
+		labels:   labelValues,
+		interval: metric.Interval,
+		intervalSeries: archive.Model{
+			Experiment: metrics.target,
+			Hostname:   metrics.hostname,
+			Metric:     metric.MlabName,
+			Labels:     labels,
+			Samples:    []archive.Sample{},
+		},
+	}
+}
+
+// pruneRows removes the rows of the walked tables that were not seen by the
+// walk, as when an entity was removed from the switch. Their unwritten samples
+// are retired and their series are dropped from the collectors.
+// metrics.mutex must be held.
+func (metrics *Metrics) pruneRows(walked map[string]bool, seen map[string]bool) {
+	for oidStr, o := range metrics.oids {
+		if o.scope != config.Table || !walked[o.name] || seen[oidStr] {
+			continue
+		}
+		if len(o.intervalSeries.Samples) > 0 {
+			metrics.retired = append(metrics.retired, o.intervalSeries)
+		}
+		metrics.deleteSeries(o)
+		delete(metrics.oids, oidStr)
+	}
+}
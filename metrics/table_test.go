@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/nkinkade/disco-go/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/soniah/gosnmp"
+)
+
+const (
+	entPhySensorValueOidStub = ".1.3.6.1.2.1.99.1.1.1.4"
+	entPhysicalNameOidStub   = ".1.3.6.1.2.1.47.1.1.1.1.7"
+)
+
+// sensorRows are the rows returned when walking entPhySensorValue, by run.
+var sensorRows = map[int][]gosnmp.SnmpPDU{
+	1: {
+		{Name: entPhySensorValueOidStub + ".1001", Type: gosnmp.Integer, Value: 41},
+		{Name: entPhySensorValueOidStub + ".1002", Type: gosnmp.Integer, Value: -250},
+	},
+	2: {
+		{Name: entPhySensorValueOidStub + ".1001", Type: gosnmp.Integer, Value: 43},
+		{Name: entPhySensorValueOidStub + ".1002", Type: gosnmp.Integer, Value: -245},
+		{Name: entPhySensorValueOidStub + ".1003", Type: gosnmp.Integer, Value: 12},
+	},
+	3: {
+		{Name: entPhySensorValueOidStub + ".1001", Type: gosnmp.Integer, Value: 42},
+		{Name: entPhySensorValueOidStub + ".1003", Type: gosnmp.Integer, Value: 13},
+	},
+}
+
+// sensorNames are the rows returned when walking entPhysicalName.
+var sensorNames = []gosnmp.SnmpPDU{
+	{Name: entPhysicalNameOidStub + ".1001", Type: gosnmp.OctetString, Value: []byte("CPU temp")},
+	{Name: entPhysicalNameOidStub + ".1002", Type: gosnmp.OctetString, Value: []byte("xe-0/0/45 Rx power")},
+	{Name: entPhysicalNameOidStub + ".1003", Type: gosnmp.OctetString, Value: []byte("PSU 0 fan")},
+}
+
+var tableConfig = config.Config{
+	Metrics: []config.Metric{
+		config.Metric{
+			Name:        "entPhySensorValue",
+			Description: "Sensor values.",
+			Type:        config.Gauge,
+			Mode:        config.Table,
+			OidStub:     entPhySensorValueOidStub,
+			MlabName:    "switch.sensor.value",
+			IndexLabel:  "entPhysicalIndex",
+			LabelOids: map[string]string{
+				"entPhysicalName": entPhysicalNameOidStub,
+			},
+		},
+	},
+}
+
+func Test_CollectTable(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s1 := &mockRealSNMP{
+		err: nil,
+		run: 1,
+	}
+	m := New(context.Background(), s1, tableConfig, target, hostname, clock.Real{})
+	if len(m.oids) != 0 {
+		t.Errorf("Expected no OIDs before the table was walked, but got: %v", len(m.oids))
+	}
+	m.Collect(context.Background(), s1)
+
+	s2 := &mockRealSNMP{
+		err: nil,
+		run: 2,
+	}
+	m.Collect(context.Background(), s2)
+
+	expectedSamples := map[string][]int64{
+		entPhySensorValueOidStub + ".1001": []int64{41, 43},
+		entPhySensorValueOidStub + ".1002": []int64{-250, -245},
+		entPhySensorValueOidStub + ".1003": []int64{12},
+	}
+	if len(m.oids) != len(expectedSamples) {
+		t.Errorf("Expected %v OIDs, but got: %v", len(expectedSamples), len(m.oids))
+	}
+	for oid, expected := range expectedSamples {
+		samples := m.oids[oid].intervalSeries.Samples
+		if len(samples) != len(expected) {
+			t.Errorf("For OID %v expected %v samples, but got: %v", oid, len(expected), len(samples))
+			continue
+		}
+		for i, sample := range samples {
+			if sample.Value != expected[i] {
+				t.Errorf("For OID %v expected sample %v to be %v, but got: %v", oid, i, expected[i], sample.Value)
+			}
+		}
+	}
+
+	o := m.oids[entPhySensorValueOidStub+".1002"]
+	expectedLabels := map[string]string{
+		"entPhysicalIndex": "1002",
+		"entPhysicalName":  "xe-0/0/45 Rx power",
+	}
+	if !reflect.DeepEqual(o.intervalSeries.Labels, expectedLabels) {
+		t.Errorf("Expected archive labels %v, but got: %v", expectedLabels, o.intervalSeries.Labels)
+	}
+	expectedValues := []string{hostname, "1002", "xe-0/0/45 Rx power"}
+	if !reflect.DeepEqual(o.labelValues(hostname), expectedValues) {
+		t.Errorf("Expected label values %v, but got: %v", expectedValues, o.labelValues(hostname))
+	}
+}
+
+// countingSNMP counts the walks and GETs made through it.
+type countingSNMP struct {
+	*mockRealSNMP
+	walks map[string]int
+	gets  int
+}
+
+func (c *countingSNMP) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	c.walks[rootOid]++
+	return c.mockRealSNMP.BulkWalkAll(ctx, rootOid)
+}
+
+func (c *countingSNMP) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	c.gets++
+	return c.mockRealSNMP.Get(ctx, oids)
+}
+
+func Test_CollectTableWalksLabelsOnce(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s := &countingSNMP{mockRealSNMP: &mockRealSNMP{run: 2}, walks: map[string]int{}}
+	m := New(context.Background(), s, tableConfig, target, hostname, clock.Real{})
+	// Only count the requests of collection, not those of discovery.
+	s.walks, s.gets = map[string]int{}, 0
+	m.Collect(context.Background(), s)
+	if s.walks[entPhysicalNameOidStub] != 1 || s.gets != 0 {
+		t.Errorf("Expected 1 walk of the label OID and no GETs for 3 new rows, but got %v walks and %v GETs",
+			s.walks[entPhysicalNameOidStub], s.gets)
+	}
+	if name := m.oids[entPhySensorValueOidStub+".1003"].intervalSeries.Labels["entPhysicalName"]; name != "PSU 0 fan" {
+		t.Errorf("Expected entPhysicalName PSU 0 fan, but got: %q", name)
+	}
+
+	// The label OID is not walked again when there are no new rows.
+	m.Collect(context.Background(), s)
+	if s.walks[entPhysicalNameOidStub] != 1 {
+		t.Errorf("Expected no more walks of the label OID, but got: %v", s.walks[entPhysicalNameOidStub])
+	}
+}
+
+func Test_CollectTablePrunesRows(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	s := &mockRealSNMP{run: 2}
+	m := New(context.Background(), s, tableConfig, target, hostname, clock.Real{})
+	m.Collect(context.Background(), s)
+	if n := testutil.CollectAndCount(m.promGauge["entPhySensorValue"]); n != 3 {
+		t.Fatalf("Expected 3 series, but got: %v", n)
+	}
+
+	// Sensor 1002 is gone from the third walk.
+	s.run = 3
+	m.Collect(context.Background(), s)
+
+	if _, ok := m.oids[entPhySensorValueOidStub+".1002"]; ok {
+		t.Errorf("Expected the row that disappeared to be pruned")
+	}
+	if len(m.oids) != 2 {
+		t.Errorf("Expected 2 OIDs, but got: %v", len(m.oids))
+	}
+	if n := testutil.CollectAndCount(m.promGauge["entPhySensorValue"]); n != 2 {
+		t.Errorf("Expected 2 series, but got: %v", n)
+	}
+	if len(m.retired) != 1 || m.retired[0].Labels["entPhysicalIndex"] != "1002" || len(m.retired[0].Samples) != 1 {
+		t.Errorf("Expected the samples of row 1002 to be retired, but got: %v", m.retired)
+	}
+}
+
+func Test_CollectScalar(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	scalarConfig := config.Config{
+		Metrics: []config.Metric{
+			config.Metric{
+				Name:        "sysUpTime",
+				Description: "System uptime.",
+				Type:        config.TimeTicks,
+				Mode:        config.Scalar,
+				OidStub:     sysUpTimeOID,
+				MlabName:    "switch.uptime",
+			},
+		},
+	}
+
+	s := &mockRealSNMP{}
+	m := New(context.Background(), s, scalarConfig, target, hostname, clock.Real{})
+	err := m.Collect(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Did not expect an error, but got: %v", err)
+	}
+
+	samples := m.oids[sysUpTimeOID].intervalSeries.Samples
+	if len(samples) != 1 || samples[0].Value != 1592000258 {
+		t.Errorf("Expected one sample with value 1592000258, but got: %v", samples)
+	}
+	if m.oids[sysUpTimeOID].intervalSeries.Metric != "switch.uptime" {
+		t.Errorf("Expected archive metric switch.uptime, but got: %v", m.oids[sysUpTimeOID].intervalSeries.Metric)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nkinkade/disco-go/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	configReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "disco_config_reloads_total",
+			Help: "The number of metrics configuration reloads, by result.",
+		},
+		[]string{"result"},
+	)
+	configLastReloadSuccessful = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "disco_config_last_reload_successful",
+			Help: "Whether the last metrics configuration reload succeeded (1) or failed (0).",
+		},
+	)
+)
+
+// reloadConfig loads the metrics configuration again and applies it to m. If
+// the new configuration cannot be loaded or applied then the old one is kept.
+func reloadConfig(m *metrics.Metrics) {
+	c, err := loadConfig()
+	if err == nil {
+		err = m.Reload(c)
+	}
+	if err != nil {
+		slog.Error("Failed to reload the metrics config, keeping the old config", "file", *fMetricsFile, "error", err)
+		configReloads.WithLabelValues("failure").Inc()
+		configLastReloadSuccessful.Set(0)
+		return
+	}
+	slog.Info("Reloaded the metrics config", "file", *fMetricsFile)
+	configReloads.WithLabelValues("success").Inc()
+	configLastReloadSuccessful.Set(1)
+}
+
+// watchConfig reloads the metrics configuration whenever a SIGHUP is received
+// and, if interval is non-zero, whenever the contents of the metrics file have
+// changed when checked every interval. It returns when ctx is done.
+func watchConfig(ctx context.Context, m *metrics.Metrics, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var check <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	// The contents are compared rather than the modification time, since a
+	// mounted Kubernetes ConfigMap is updated by swapping a symlink.
+	last, _ := ioutil.ReadFile(*fMetricsFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last, _ = ioutil.ReadFile(*fMetricsFile)
+			reloadConfig(m)
+		case <-check:
+			data, err := ioutil.ReadFile(*fMetricsFile)
+			if err != nil || bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+			reloadConfig(m)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package scheduler runs jobs at fixed intervals aligned to wall clock
+// boundaries, without drifting.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ticksSkipped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "disco_scheduler_skipped_ticks_total",
+			Help: "The number of scheduled runs of a job that were skipped because the previous run overran, or the process was not running.",
+		},
+		[]string{"job"},
+	)
+	lastTick = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "disco_scheduler_last_tick_timestamp_seconds",
+			Help: "The time of the most recent scheduled run of a job.",
+		},
+		[]string{"job"},
+	)
+)
+
+// Scheduler runs a job at every multiple of its interval since the zero time,
+// so that with an interval that divides a day the runs fall on the same wall
+// clock boundaries every day, e.g. :00, :10, :20 for 10s. Each run is timed
+// from the clock rather than from the previous run, so the schedule never
+// drifts.
+type Scheduler struct {
+	name     string
+	interval time.Duration
+	clock    clock.Clock
+	job      func(tick time.Time)
+	// awake is when Run last woke up or finished a run of the job, or zero if
+	// it isn't running.
+	mutex sync.Mutex
+	awake time.Time
+}
+
+// New returns a Scheduler that runs job, named name in logs and metrics, every
+// interval according to c. The job is passed the boundary it was run for.
+func New(name string, interval time.Duration, c clock.Clock, job func(tick time.Time)) *Scheduler {
+	return &Scheduler{
+		name:     name,
+		interval: interval,
+		clock:    c,
+		job:      job,
+	}
+}
+
+// Name returns the name of the job.
+func (s *Scheduler) Name() string {
+	return s.name
+}
+
+// Next returns the first boundary of the schedule after t.
+func (s *Scheduler) Next(t time.Time) time.Time {
+	return t.Truncate(s.interval).Add(s.interval)
+}
+
+// Run runs the job at each boundary, starting with the next one, until ctx is
+// done. Runs never overlap: the boundaries that pass while the job is running
+// are skipped, logged and counted in the disco_scheduler_skipped_ticks_total
+// metric, as are any that pass while the process isn't running, such as while
+// the system is suspended.
+func (s *Scheduler) Run(ctx context.Context) {
+	defer s.wake(time.Time{})
+	s.wake(s.clock.Now())
+	next := s.Next(s.clock.Now())
+	for {
+		timer := s.clock.NewTimer(next.Sub(s.clock.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		now := s.clock.Now()
+		s.wake(now)
+		if now.Before(next) {
+			// The clock was set back while waiting, so the schedule starts
+			// again from the new time.
+			if next.Sub(now) > s.interval {
+				next = s.Next(now)
+			}
+			continue
+		}
+		if now.Sub(next) >= s.interval {
+			following := s.Next(now)
+			s.skip(following.Sub(next)/s.interval, "the process was not running")
+			next = following
+			continue
+		}
+
+		lastTick.WithLabelValues(s.name).Set(float64(next.Unix()))
+		s.job(next)
+		s.wake(s.clock.Now())
+
+		following := s.Next(s.clock.Now())
+		s.skip(following.Sub(next)/s.interval-1, "the previous run overran")
+		next = following
+	}
+}
+
+// Check returns an error if Run isn't running, or hasn't woken up or finished
+// a run of the job for two intervals, as when the job is stuck.
+func (s *Scheduler) Check() error {
+	s.mutex.Lock()
+	awake := s.awake
+	s.mutex.Unlock()
+	if awake.IsZero() {
+		return fmt.Errorf("%v is not running", s.name)
+	}
+	if since := s.clock.Now().Sub(awake); since > 2*s.interval {
+		return fmt.Errorf("%v has not run for %v, more than twice its interval of %v", s.name, since, s.interval)
+	}
+	return nil
+}
+
+// wake records that Run woke up at now.
+func (s *Scheduler) wake(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.awake = now
+}
+
+// skip records that n boundaries were skipped for reason.
+func (s *Scheduler) skip(n time.Duration, reason string) {
+	if n <= 0 {
+		return
+	}
+	slog.Warn("Skipped scheduled runs", "job", s.name, "runs", int64(n), "reason", reason)
+	ticksSkipped.WithLabelValues(s.name).Add(float64(n))
+}
@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nkinkade/disco-go/clock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var start = time.Date(2020, 6, 11, 23, 59, 3, 500000000, time.UTC)
+
+// run starts a scheduler on a fake clock, returning the clock and a channel of
+// the ticks the job is run for. The job calls during, if set, with each tick.
+func run(t *testing.T, name string, interval time.Duration, during func(*clock.Fake)) (*clock.Fake, chan time.Time, context.CancelFunc) {
+	fake := clock.NewFake(start)
+	ticks := make(chan time.Time, 100)
+	s := New(name, interval, fake, func(tick time.Time) {
+		if during != nil {
+			during(fake)
+		}
+		ticks <- tick
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Run() did not return after its context was cancelled")
+		}
+	})
+	return fake, ticks, cancel
+}
+
+func Test_SchedulerAligned(t *testing.T) {
+	// The counters are global, so only their increase over the test is checked.
+	before := testutil.ToFloat64(ticksSkipped.WithLabelValues("aligned"))
+	fake, ticks, _ := run(t, "aligned", 10*time.Second, nil)
+
+	// A day of runs, each woken up a little late, lands on exact boundaries.
+	want := time.Date(2020, 6, 11, 23, 59, 10, 0, time.UTC)
+	for i := 0; i < 8640; i++ {
+		fake.BlockUntil(1)
+		fake.Set(want.Add(3 * time.Millisecond))
+		if got := <-ticks; !got.Equal(want) {
+			t.Fatalf("Run %v was for %v, want %v", i, got, want)
+		}
+		want = want.Add(10 * time.Second)
+	}
+	if skipped := testutil.ToFloat64(ticksSkipped.WithLabelValues("aligned")) - before; skipped != 0 {
+		t.Errorf("Expected no skipped ticks, got %v", skipped)
+	}
+	if last := testutil.ToFloat64(lastTick.WithLabelValues("aligned")); last != float64(want.Add(-10*time.Second).Unix()) {
+		t.Errorf("disco_scheduler_last_tick_timestamp_seconds = %v", last)
+	}
+}
+
+func Test_SchedulerWriteBoundaries(t *testing.T) {
+	fake, ticks, _ := run(t, "write", 5*time.Minute, nil)
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	if got, want := <-ticks, time.Date(2020, 6, 12, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("First write was for %v, want %v", got, want)
+	}
+}
+
+func Test_SchedulerOverrun(t *testing.T) {
+	before := testutil.ToFloat64(ticksSkipped.WithLabelValues("overrun"))
+	overrun := true
+	fake, ticks, _ := run(t, "overrun", 10*time.Second, func(fake *clock.Fake) {
+		if overrun {
+			overrun = false
+			fake.Advance(25 * time.Second)
+		}
+	})
+
+	fake.BlockUntil(1)
+	fake.Advance(7 * time.Second)
+	<-ticks
+	fake.BlockUntil(1)
+	fake.Advance(5 * time.Second)
+	if got, want := <-ticks, time.Date(2020, 6, 11, 23, 59, 40, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Run after an overrun was for %v, want %v", got, want)
+	}
+	if skipped := testutil.ToFloat64(ticksSkipped.WithLabelValues("overrun")) - before; skipped != 2 {
+		t.Errorf("Expected 2 skipped ticks, got %v", skipped)
+	}
+}
+
+func Test_SchedulerSuspended(t *testing.T) {
+	before := testutil.ToFloat64(ticksSkipped.WithLabelValues("suspended"))
+	fake, ticks, _ := run(t, "suspended", 10*time.Second, nil)
+
+	fake.BlockUntil(1)
+	fake.Advance(35 * time.Second)
+	fake.BlockUntil(1)
+	fake.Advance(5 * time.Second)
+	if got, want := <-ticks, time.Date(2020, 6, 11, 23, 59, 40, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Run after a suspension was for %v, want %v", got, want)
+	}
+	if skipped := testutil.ToFloat64(ticksSkipped.WithLabelValues("suspended")) - before; skipped != 3 {
+		t.Errorf("Expected 3 skipped ticks, got %v", skipped)
+	}
+}
+
+func Test_SchedulerCheck(t *testing.T) {
+	fake := clock.NewFake(start)
+	release := make(chan struct{})
+	running := make(chan struct{})
+	s := New("check", 10*time.Second, fake, func(tick time.Time) {
+		running <- struct{}{}
+		<-release
+	})
+	if err := s.Check(); err == nil {
+		t.Errorf("Expected Check() to fail before Run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	fake.BlockUntil(1)
+	if err := s.Check(); err != nil {
+		t.Errorf("Check() while waiting error = %v", err)
+	}
+
+	// The job gets stuck for longer than two intervals.
+	fake.Advance(10 * time.Second)
+	<-running
+	fake.Advance(25 * time.Second)
+	if err := s.Check(); err == nil {
+		t.Errorf("Expected Check() to fail while the job is stuck")
+	}
+	close(release)
+	fake.BlockUntil(1)
+	if err := s.Check(); err != nil {
+		t.Errorf("Check() once the job finished error = %v", err)
+	}
+
+	cancel()
+	<-done
+	if err := s.Check(); err == nil {
+		t.Errorf("Expected Check() to fail once Run returned")
+	}
+}
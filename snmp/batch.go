@@ -0,0 +1,87 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Batched wraps an SNMP, splitting each Get into requests of at most MaxOids
+// OIDs and merging the responses back into a single packet. A request that an
+// agent answers with a tooBig error is split in half and retried, so that
+// agents with a small maximum PDU size can still be scraped.
+type Batched struct {
+	SNMP
+	MaxOids int
+}
+
+// NewBatched returns a new Batched wrapping s. If maxOids is not positive then
+// the gosnmp default is used.
+func NewBatched(s SNMP, maxOids int) *Batched {
+	if maxOids <= 0 {
+		maxOids = gosnmp.MaxOids
+	}
+	return &Batched{
+		SNMP:    s,
+		MaxOids: maxOids,
+	}
+}
+
+// Get does an SNMP Get operation on an array of OIDs, in as many requests as
+// needed. The variables of the returned packet are in the same order as the
+// OIDs. If any request fails then no result is returned.
+func (b *Batched) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	merged := &gosnmp.SnmpPacket{
+		Variables: []gosnmp.SnmpPDU{},
+	}
+	for start := 0; start < len(oids); start += b.MaxOids {
+		end := start + b.MaxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		err := b.get(ctx, oids[start:end], merged)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// get requests oids, splitting the request on tooBig errors, and appends the
+// variables of the responses to merged. The first error-status other than
+// tooBig is recorded in merged, with its index relative to all merged
+// variables, or zero if that does not fit in the packet's ErrorIndex. Callers
+// that rely on the index, such as to skip an OID an SNMPv1 agent doesn't have,
+// should therefore get no more than 255 OIDs at a time.
+func (b *Batched) get(ctx context.Context, oids []string, merged *gosnmp.SnmpPacket) error {
+	result, err := b.SNMP.Get(ctx, oids)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return fmt.Errorf("no results returned for OIDs: %v", oids)
+	}
+
+	if result.Error == gosnmp.TooBig {
+		if len(oids) == 1 {
+			return fmt.Errorf("response for OID %v is too big", oids[0])
+		}
+		half := len(oids) / 2
+		err = b.get(ctx, oids[:half], merged)
+		if err != nil {
+			return err
+		}
+		return b.get(ctx, oids[half:], merged)
+	}
+
+	if result.Error != gosnmp.NoError && merged.Error == gosnmp.NoError {
+		merged.Error = result.Error
+		if index := int(result.ErrorIndex) + len(merged.Variables); index <= math.MaxUint8 {
+			merged.ErrorIndex = uint8(index)
+		}
+	}
+	merged.Variables = append(merged.Variables, result.Variables...)
+	return nil
+}
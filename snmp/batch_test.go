@@ -0,0 +1,138 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/soniah/gosnmp"
+)
+
+// mockAgent answers Gets with the index of each OID as its value, replying
+// tooBig to any request for more than maxOids OIDs.
+type mockAgent struct {
+	SNMP
+	maxOids  int
+	requests [][]string
+	err      error
+}
+
+func (m *mockAgent) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	return nil, nil
+}
+
+func (m *mockAgent) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	m.requests = append(m.requests, oids)
+	if m.err != nil {
+		return nil, m.err
+	}
+	if len(oids) > m.maxOids {
+		return &gosnmp.SnmpPacket{Error: gosnmp.TooBig}, nil
+	}
+	packet := &gosnmp.SnmpPacket{}
+	for _, oid := range oids {
+		var i int
+		fmt.Sscanf(oid, ".1.%d", &i)
+		packet.Variables = append(packet.Variables, gosnmp.SnmpPDU{
+			Name:  oid,
+			Type:  gosnmp.Integer,
+			Value: i,
+		})
+	}
+	return packet, nil
+}
+
+func testOids(n int) []string {
+	oids := []string{}
+	for i := 0; i < n; i++ {
+		oids = append(oids, fmt.Sprintf(".1.%d", i))
+	}
+	return oids
+}
+
+func Test_BatchedGet(t *testing.T) {
+	tests := []struct {
+		name         string
+		oids         int
+		maxOids      int
+		agentMaxOids int
+		requestSizes []int
+	}{
+		{
+			name:         "single-request",
+			oids:         5,
+			maxOids:      10,
+			agentMaxOids: 10,
+			requestSizes: []int{5},
+		},
+		{
+			name:         "batched",
+			oids:         25,
+			maxOids:      10,
+			agentMaxOids: 10,
+			requestSizes: []int{10, 10, 5},
+		},
+		{
+			name:         "split-on-too-big",
+			oids:         12,
+			maxOids:      12,
+			agentMaxOids: 4,
+			requestSizes: []int{12, 6, 3, 3, 6, 3, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		agent := &mockAgent{maxOids: tt.agentMaxOids}
+		b := NewBatched(agent, tt.maxOids)
+		oids := testOids(tt.oids)
+
+		result, err := b.Get(context.Background(), oids)
+		if err != nil {
+			t.Fatalf("%v: Get() returned an unexpected error: %v", tt.name, err)
+		}
+		sizes := []int{}
+		for _, r := range agent.requests {
+			sizes = append(sizes, len(r))
+		}
+		if !reflect.DeepEqual(sizes, tt.requestSizes) {
+			t.Errorf("%v: unexpected request sizes.\nGot: %v\nExpected: %v", tt.name, sizes, tt.requestSizes)
+		}
+		if len(result.Variables) != len(oids) {
+			t.Fatalf("%v: expected %v variables, but got: %v", tt.name, len(oids), len(result.Variables))
+		}
+		for i, pdu := range result.Variables {
+			if pdu.Name != oids[i] || pdu.Value != i {
+				t.Errorf("%v: expected variable %v to be %v=%v, but got: %v=%v", tt.name, i, oids[i], i, pdu.Name, pdu.Value)
+			}
+		}
+	}
+}
+
+func Test_BatchedGetTooBigSingleOid(t *testing.T) {
+	agent := &mockAgent{maxOids: 0}
+	b := NewBatched(agent, 10)
+	_, err := b.Get(context.Background(), testOids(2))
+	if err == nil {
+		t.Error("Expected an error for a single OID that is too big, but didn't get one")
+	}
+}
+
+func Test_BatchedGetError(t *testing.T) {
+	agent := &mockAgent{maxOids: 10, err: fmt.Errorf("request timeout")}
+	b := NewBatched(agent, 10)
+	result, err := b.Get(context.Background(), testOids(20))
+	if err == nil || result != nil {
+		t.Errorf("Expected an error and no result, but got: %v, %v", result, err)
+	}
+	if len(agent.requests) != 1 {
+		t.Errorf("Expected requests to stop after the first error, but got %v requests", len(agent.requests))
+	}
+}
+
+func Test_NewBatchedDefault(t *testing.T) {
+	b := NewBatched(&mockAgent{}, 0)
+	if b.MaxOids != gosnmp.MaxOids {
+		t.Errorf("Expected MaxOids to default to %v, but got: %v", gosnmp.MaxOids, b.MaxOids)
+	}
+}
@@ -0,0 +1,217 @@
+package snmp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	authProtocols = []gosnmp.SnmpV3AuthProtocol{
+		gosnmp.MD5, gosnmp.SHA, gosnmp.SHA224, gosnmp.SHA256, gosnmp.SHA384, gosnmp.SHA512,
+	}
+	privProtocols = []gosnmp.SnmpV3PrivProtocol{
+		gosnmp.DES, gosnmp.AES, gosnmp.AES192, gosnmp.AES256, gosnmp.AES192C, gosnmp.AES256C,
+	}
+)
+
+// Credentials are the secrets used to authenticate to an SNMP agent: either an
+// SNMPv2c community or, if Username is set, SNMPv3 USM credentials. An SNMPv3
+// user has authentication if AuthProtocol is set and privacy if PrivProtocol
+// is set. Credentials never format their secrets, so they are safe to log.
+type Credentials struct {
+	Community      string `yaml:"-"`
+	Username       string `yaml:"username"`
+	AuthProtocol   string `yaml:"authProtocol"`
+	AuthPassphrase string `yaml:"authPassphrase"`
+	PrivProtocol   string `yaml:"privProtocol"`
+	PrivPassphrase string `yaml:"privPassphrase"`
+}
+
+// String describes the credentials without revealing their secrets.
+func (c Credentials) String() string {
+	if c.Username == "" {
+		return "SNMPv2c community <redacted>"
+	}
+	return fmt.Sprintf("SNMPv3 user %v (auth: %v, priv: %v)", c.Username, orNone(c.AuthProtocol), orNone(c.PrivProtocol))
+}
+
+// GoString describes the credentials without revealing their secrets, for
+// formatting with %#v.
+func (c Credentials) GoString() string {
+	return c.String()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// Validate checks that the credentials are complete and that any SNMPv3
+// protocols are known.
+func (c Credentials) Validate() error {
+	if c.Username == "" {
+		if c.Community == "" {
+			return fmt.Errorf("the SNMP community is empty")
+		}
+		return nil
+	}
+	if c.AuthProtocol == "" && c.PrivProtocol != "" {
+		return fmt.Errorf("SNMPv3 privacy requires authentication")
+	}
+	if c.AuthProtocol != "" {
+		if _, ok := authProtocol(c.AuthProtocol); !ok {
+			return fmt.Errorf("unknown SNMPv3 authProtocol '%v'", c.AuthProtocol)
+		}
+		if c.AuthPassphrase == "" {
+			return fmt.Errorf("SNMPv3 authPassphrase is required with authProtocol")
+		}
+	}
+	if c.PrivProtocol != "" {
+		if _, ok := privProtocol(c.PrivProtocol); !ok {
+			return fmt.Errorf("unknown SNMPv3 privProtocol '%v'", c.PrivProtocol)
+		}
+		if c.PrivPassphrase == "" {
+			return fmt.Errorf("SNMPv3 privPassphrase is required with privProtocol")
+		}
+	}
+	return nil
+}
+
+func authProtocol(name string) (gosnmp.SnmpV3AuthProtocol, bool) {
+	for _, p := range authProtocols {
+		if strings.EqualFold(p.String(), name) {
+			return p, true
+		}
+	}
+	return gosnmp.NoAuth, false
+}
+
+func privProtocol(name string) (gosnmp.SnmpV3PrivProtocol, bool) {
+	for _, p := range privProtocols {
+		if strings.EqualFold(p.String(), name) {
+			return p, true
+		}
+	}
+	return gosnmp.NoPriv, false
+}
+
+// apply sets the credentials on a gosnmp client. A community is used with
+// SNMPv1 if the client is already set to that version, and SNMPv2c otherwise.
+// Validate must have been called first.
+func (c Credentials) apply(g *gosnmp.GoSNMP) {
+	if c.Username == "" {
+		if g.Version != gosnmp.Version1 {
+			g.Version = gosnmp.Version2c
+		}
+		g.Community = c.Community
+		return
+	}
+	usm := &gosnmp.UsmSecurityParameters{
+		UserName:               c.Username,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+	g.MsgFlags = gosnmp.NoAuthNoPriv
+	if p, ok := authProtocol(c.AuthProtocol); ok {
+		usm.AuthenticationProtocol = p
+		usm.AuthenticationPassphrase = c.AuthPassphrase
+		g.MsgFlags = gosnmp.AuthNoPriv
+	}
+	if p, ok := privProtocol(c.PrivProtocol); ok {
+		usm.PrivacyProtocol = p
+		usm.PrivacyPassphrase = c.PrivPassphrase
+		g.MsgFlags = gosnmp.AuthPriv
+	}
+	g.Version = gosnmp.Version3
+	g.Community = ""
+	g.SecurityModel = gosnmp.UserSecurityModel
+	g.SecurityParameters = usm
+}
+
+// CredentialsFile reads credentials from a file, such as a mounted Kubernetes
+// secret. The file holds either a bare SNMPv2c community or, for SNMPv3, a
+// YAML document of Credentials. The file is read again whenever its
+// modification time or size changes, so credentials can be rotated without a
+// restart, while a file that hasn't changed costs only a stat per request.
+type CredentialsFile struct {
+	path    string
+	v3      bool
+	mutex   sync.Mutex
+	data    []byte
+	modTime time.Time
+	size    int64
+	creds   Credentials
+}
+
+// NewCredentialsFile returns a CredentialsFile for the file at path, which
+// holds SNMPv3 credentials if v3 is set and a community otherwise. The file
+// is read and validated immediately.
+func NewCredentialsFile(path string, v3 bool) (*CredentialsFile, error) {
+	f := &CredentialsFile{
+		path: path,
+		v3:   v3,
+	}
+	_, err := f.Get()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Get returns the current credentials, reading the file again only if it has
+// changed since it was last read. If the file can't be read or its new
+// contents are invalid then an error is returned; the error never includes the
+// contents of the file.
+func (f *CredentialsFile) Get() (Credentials, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read SNMP credentials file: %v", err)
+	}
+	if f.data != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.creds, nil
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read SNMP credentials file: %v", err)
+	}
+	if f.data != nil && bytes.Equal(data, f.data) {
+		f.modTime, f.size = info.ModTime(), info.Size()
+		return f.creds, nil
+	}
+
+	creds := Credentials{}
+	if f.v3 {
+		err = yaml.Unmarshal(data, &creds)
+		if err != nil {
+			// yaml errors may quote the document, so aren't passed on.
+			return Credentials{}, fmt.Errorf("failed to parse SNMPv3 credentials file %v", f.path)
+		}
+		if creds.Username == "" {
+			return Credentials{}, fmt.Errorf("SNMPv3 credentials file %v has no username", f.path)
+		}
+	} else {
+		creds.Community = strings.TrimSpace(string(data))
+	}
+	err = creds.Validate()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid SNMP credentials in %v: %v", f.path, err)
+	}
+
+	f.data = data
+	f.modTime, f.size = info.ModTime(), info.Size()
+	f.creds = creds
+	return creds, nil
+}
@@ -0,0 +1,246 @@
+package snmp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+func writeFile(t *testing.T, name string, contents string) {
+	err := ioutil.WriteFile(name, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write %v: %v", name, err)
+	}
+}
+
+func Test_CredentialsFileCommunity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disco-creds")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "community")
+
+	writeFile(t, name, "snmp-community\n")
+	f, err := NewCredentialsFile(name, false)
+	if err != nil {
+		t.Fatalf("NewCredentialsFile() returned an unexpected error: %v", err)
+	}
+	creds, _ := f.Get()
+	if creds.Community != "snmp-community" {
+		t.Errorf("Expected the community to be read and trimmed, but got: %q", creds.Community)
+	}
+
+	// The file is read again when it is rotated.
+	writeFile(t, name, "rotated-community")
+	creds, _ = f.Get()
+	if creds.Community != "rotated-community" {
+		t.Errorf("Expected the rotated community, but got: %q", creds.Community)
+	}
+
+	writeFile(t, name, "  \n")
+	_, err = f.Get()
+	if err == nil {
+		t.Error("Expected an error for an empty community, but didn't get one")
+	}
+
+	os.Remove(name)
+	_, err = NewCredentialsFile(name, false)
+	if err == nil {
+		t.Error("Expected an error for a missing file, but didn't get one")
+	}
+}
+
+func Test_CredentialsFileUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disco-creds")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "community")
+
+	writeFile(t, name, "community-a")
+	f, err := NewCredentialsFile(name, false)
+	if err != nil {
+		t.Fatalf("NewCredentialsFile() returned an unexpected error: %v", err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Failed to stat the file: %v", err)
+	}
+
+	// The file is not read again while its modification time and size are
+	// the same, so a change that keeps both isn't seen.
+	writeFile(t, name, "community-b")
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Failed to set the modification time: %v", err)
+	}
+	if creds, _ := f.Get(); creds.Community != "community-a" {
+		t.Errorf("Expected the file not to be read again, but got: %q", creds.Community)
+	}
+
+	modified := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatalf("Failed to set the modification time: %v", err)
+	}
+	if creds, _ := f.Get(); creds.Community != "community-b" {
+		t.Errorf("Expected the modified file to be read again, but got: %q", creds.Community)
+	}
+}
+
+func Test_CredentialsFileV3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disco-creds")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "v3.yaml")
+
+	tests := []struct {
+		name     string
+		contents string
+		want     Credentials
+		wantErr  bool
+	}{
+		{
+			name:     "auth-priv",
+			contents: "username: disco\nauthProtocol: SHA256\nauthPassphrase: secret-auth\nprivProtocol: aes\nprivPassphrase: secret-priv\n",
+			want:     Credentials{Username: "disco", AuthProtocol: "SHA256", AuthPassphrase: "secret-auth", PrivProtocol: "aes", PrivPassphrase: "secret-priv"},
+		},
+		{
+			name:     "no-auth-no-priv",
+			contents: "username: disco\n",
+			want:     Credentials{Username: "disco"},
+		},
+		{
+			name:     "no-username",
+			contents: "authProtocol: SHA\nauthPassphrase: secret-auth\n",
+			wantErr:  true,
+		},
+		{
+			name:     "unknown-protocol",
+			contents: "username: disco\nauthProtocol: SHA3\nauthPassphrase: secret-auth\n",
+			wantErr:  true,
+		},
+		{
+			name:     "priv-without-auth",
+			contents: "username: disco\nprivProtocol: AES\nprivPassphrase: secret-priv\n",
+			wantErr:  true,
+		},
+		{
+			name:     "missing-passphrase",
+			contents: "username: disco\nauthProtocol: SHA\n",
+			wantErr:  true,
+		},
+		{
+			name:     "malformed",
+			contents: "username: [secret-value\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		writeFile(t, name, tt.contents)
+		f, err := NewCredentialsFile(name, true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: NewCredentialsFile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "secret") {
+				t.Errorf("%v: error reveals the contents of the file: %v", tt.name, err)
+			}
+			continue
+		}
+		creds, _ := f.Get()
+		if creds != tt.want {
+			t.Errorf("%v: unexpected credentials.\nGot: %#v\nExpected: %#v", tt.name, creds, tt.want)
+		}
+	}
+}
+
+func Test_CredentialsString(t *testing.T) {
+	for _, creds := range []Credentials{
+		{Community: "secret-community"},
+		{Username: "disco", AuthProtocol: "SHA", AuthPassphrase: "secret-auth", PrivProtocol: "AES", PrivPassphrase: "secret-priv"},
+	} {
+		for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+			s := fmt.Sprintf(format, creds)
+			if strings.Contains(s, "secret") {
+				t.Errorf("Formatting credentials with %v revealed a secret: %v", format, s)
+			}
+		}
+	}
+}
+
+func Test_CredentialsApply(t *testing.T) {
+	g := &gosnmp.GoSNMP{}
+	creds := Credentials{Username: "disco", AuthProtocol: "sha", AuthPassphrase: "secret-auth", PrivProtocol: "AES256", PrivPassphrase: "secret-priv"}
+	creds.apply(g)
+	usm, ok := g.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if g.Version != gosnmp.Version3 || g.MsgFlags != gosnmp.AuthPriv || g.SecurityModel != gosnmp.UserSecurityModel || !ok {
+		t.Fatalf("Expected SNMPv3 authPriv settings, but got: version %v, flags %v, model %v", g.Version, g.MsgFlags, g.SecurityModel)
+	}
+	if usm.UserName != "disco" || usm.AuthenticationProtocol != gosnmp.SHA || usm.PrivacyProtocol != gosnmp.AES256 ||
+		usm.AuthenticationPassphrase != "secret-auth" || usm.PrivacyPassphrase != "secret-priv" {
+		t.Errorf("Unexpected USM security parameters: %+v", usm)
+	}
+
+	Credentials{Community: "snmp-community"}.apply(g)
+	if g.Version != gosnmp.Version2c || g.Community != "snmp-community" {
+		t.Errorf("Expected SNMPv2c settings, but got: version %v, community %v", g.Version, g.Community)
+	}
+
+	g = &gosnmp.GoSNMP{Version: gosnmp.Version1}
+	Credentials{Community: "snmp-community"}.apply(g)
+	if g.Version != gosnmp.Version1 || g.Community != "snmp-community" {
+		t.Errorf("Expected SNMPv1 settings, but got: version %v, community %v", g.Version, g.Community)
+	}
+}
+
+func Test_ManagedCredentialsRotation(t *testing.T) {
+	agent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer agent.Close()
+
+	o := DefaultOptions
+	o.Port = uint(agent.LocalAddr().(*net.UDPAddr).Port)
+	m := NewManaged(o.GoSNMP("127.0.0.1"))
+	community := "snmp-community"
+	m.Credentials = func() (Credentials, error) {
+		return Credentials{Community: community}, nil
+	}
+	err = m.Connect()
+	if err != nil {
+		t.Fatalf("Connect() returned an unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	first := m.conn
+	if got := first.(*RealSNMP).GoSNMP.Community; got != community {
+		t.Errorf("Expected the connection to use community %v, but got: %v", community, got)
+	}
+
+	community = "rotated-community"
+	m.mutex.Lock()
+	c, err := m.connect()
+	m.mutex.Unlock()
+	if err != nil {
+		t.Fatalf("connect() returned an unexpected error: %v", err)
+	}
+	if c == first {
+		t.Error("Expected a new connection after the credentials changed")
+	}
+	if got := c.(*RealSNMP).GoSNMP.Community; got != community {
+		t.Errorf("Expected the new connection to use community %v, but got: %v", community, got)
+	}
+}
@@ -0,0 +1,29 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Format returns a variable as a line of text for people to read, as
+// "OID = TYPE: VALUE". Octet strings are quoted, or hex encoded if they aren't
+// printable, and exceptions such as NoSuchObject have no value.
+func Format(pdu gosnmp.SnmpPDU) string {
+	var value string
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		b, _ := pdu.Value.([]byte)
+		if printable(b) {
+			value = fmt.Sprintf("%q", b)
+		} else {
+			value = hex.EncodeToString(b)
+		}
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return fmt.Sprintf("%v = %v", pdu.Name, pdu.Type)
+	default:
+		value = fmt.Sprint(pdu.Value)
+	}
+	return fmt.Sprintf("%v = %v: %v", pdu.Name, pdu.Type, value)
+}
@@ -0,0 +1,40 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/soniah/gosnmp"
+)
+
+func Test_Format(t *testing.T) {
+	tests := []struct {
+		pdu  gosnmp.SnmpPDU
+		want string
+	}{
+		{
+			pdu:  gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("s1-abc0t")},
+			want: `.1.3.6.1.2.1.1.5.0 = OctetString: "s1-abc0t"`,
+		},
+		{
+			pdu:  gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.6.524", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1c, 0x73, 0xff}},
+			want: ".1.3.6.1.2.1.2.2.1.6.524 = OctetString: 001c73ff",
+		},
+		{
+			pdu:  gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.31.1.1.1.6.524", Type: gosnmp.Counter64, Value: uint64(1000)},
+			want: ".1.3.6.1.2.1.31.1.1.1.6.524 = Counter64: 1000",
+		},
+		{
+			pdu:  gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.2636.1.1.1.2.82"},
+			want: ".1.3.6.1.2.1.1.2.0 = ObjectIdentifier: .1.3.6.1.4.1.2636.1.1.1.2.82",
+		},
+		{
+			pdu:  gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.1.99.0", Type: gosnmp.NoSuchObject},
+			want: ".1.3.6.1.2.1.1.99.0 = NoSuchObject",
+		},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.pdu); got != tt.want {
+			t.Errorf("Format(%v) = %v, want %v", tt.pdu, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/soniah/gosnmp"
+)
+
+// ErrUnavailable is returned by a Managed client while its circuit breaker is
+// open, without the agent being contacted.
+var ErrUnavailable = errors.New("SNMP agent is unavailable, waiting to reconnect")
+
+var (
+	snmpUp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "disco_snmp_up",
+			Help: "Whether the last SNMP request to the switch succeeded (1) or not (0).",
+		},
+	)
+	snmpReconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "disco_snmp_reconnects_total",
+			Help: "The number of attempts to reconnect to the switch, by result.",
+		},
+		[]string{"result"},
+	)
+)
+
+// conn is a connected SNMP client.
+type conn interface {
+	SNMP
+	Close() error
+}
+
+// Managed is an SNMP client that manages its own connection to an agent. After
+// FailureThreshold consecutive failed requests the connection is closed and a
+// circuit breaker opens: requests fail immediately with ErrUnavailable until a
+// backoff has passed, after which a new connection is made, resolving the name
+// of the target again. The backoff doubles with each failed reconnection, from
+// MinBackoff up to MaxBackoff. While open, polling of the agent is slowed to
+// one attempt per backoff rather than one timeout per request.
+//
+// Requests are made one at a time, since a gosnmp connection is not safe for
+// concurrent use, so a request waits for any other in progress to finish.
+//
+// If ResolveInterval is non-zero then the name of the target is resolved at
+// that interval, and the client reconnects if its addresses have changed. If
+// SourceAddress is set then connections are made from that local IP address.
+//
+// If Credentials is set then it is called for the credentials of each new
+// connection, overriding those of the template, and before each request. If
+// the credentials have changed, for instance because a secret was rotated,
+// then the client reconnects with the new ones.
+//
+// If DisableBulk is set then BulkWalkAll walks with GetNext rather than
+// GetBulk, for agents that don't implement GetBulk; see Probe.
+type Managed struct {
+	FailureThreshold int
+	MinBackoff       time.Duration
+	MaxBackoff       time.Duration
+	ResolveInterval  time.Duration
+	SourceAddress    string
+	Credentials      func() (Credentials, error)
+	DisableBulk      bool
+
+	template gosnmp.GoSNMP
+	dial     func() (conn, error)
+	lookup   func(host string) ([]string, error)
+	now      func() time.Time
+
+	// requestMutex is held for the whole of each request, while mutex guards
+	// the state of the connection and is only held briefly.
+	requestMutex sync.Mutex
+	mutex        sync.Mutex
+	conn         conn
+	creds        Credentials
+	failures     int
+	retryAt      time.Time
+	addrs        []string
+	resolvedAt   time.Time
+}
+
+// NewManaged returns a new, unconnected, Managed client for the agent
+// described by template. The template itself is never connected; each
+// connection is made with a copy of it.
+func NewManaged(template *gosnmp.GoSNMP) *Managed {
+	m := &Managed{
+		FailureThreshold: 3,
+		MinBackoff:       10 * time.Second,
+		MaxBackoff:       5 * time.Minute,
+		ResolveInterval:  5 * time.Minute,
+		template:         *template,
+		lookup:           net.LookupHost,
+		now:              time.Now,
+	}
+	m.dial = m.dialGoSNMP
+	return m
+}
+
+// dialGoSNMP connects a copy of the template. It must be called with the mutex
+// held.
+func (m *Managed) dialGoSNMP() (conn, error) {
+	g := m.template
+	if m.Credentials != nil {
+		creds, err := m.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		creds.apply(&g)
+		m.creds = creds
+	}
+	err := g.Connect()
+	if err != nil {
+		return nil, err
+	}
+	if m.SourceAddress != "" {
+		err = bind(&g, m.SourceAddress)
+		if err != nil {
+			g.Conn.Close()
+			return nil, err
+		}
+	}
+	return Client(&g), nil
+}
+
+// Connect connects to the agent, returning an error if it can't. It need not be
+// called before making requests, but allows a failure to connect at startup to
+// be reported.
+func (m *Managed) Connect() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, err := m.connect()
+	return err
+}
+
+// connect returns the current connection, making a new one if there is none
+// and the backoff has passed. It must be called with the mutex held.
+func (m *Managed) connect() (conn, error) {
+	now := m.now()
+	if m.conn != nil && m.ResolveInterval > 0 && now.Sub(m.resolvedAt) >= m.ResolveInterval {
+		addrs, err := m.resolve()
+		if err == nil && fmt.Sprint(addrs) != fmt.Sprint(m.addrs) {
+			slog.Info("SNMP target addresses changed, reconnecting", "target", m.template.Target, "from", m.addrs, "to", addrs)
+			m.disconnect()
+		}
+	}
+	if m.conn != nil && m.Credentials != nil {
+		// Errors are ignored, so that the current connection is kept until
+		// valid credentials can be read.
+		creds, err := m.Credentials()
+		if err == nil && creds != m.creds {
+			slog.Info("SNMP credentials changed, reconnecting", "target", m.template.Target)
+			m.disconnect()
+		}
+	}
+	if m.conn != nil {
+		return m.conn, nil
+	}
+	if now.Before(m.retryAt) {
+		return nil, ErrUnavailable
+	}
+
+	c, err := m.dial()
+	if err != nil {
+		snmpReconnects.WithLabelValues("failure").Inc()
+		m.failures++
+		m.openCircuit(now)
+		return nil, err
+	}
+	snmpReconnects.WithLabelValues("success").Inc()
+	m.conn = c
+	m.addrs, _ = m.resolve()
+	return c, nil
+}
+
+// resolve looks up the addresses of the target in sorted order.
+func (m *Managed) resolve() ([]string, error) {
+	m.resolvedAt = m.now()
+	addrs, err := m.lookup(m.template.Target)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// disconnect closes the current connection, if any.
+func (m *Managed) disconnect() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
+
+// openCircuit closes the connection and stops requests from being made until
+// a backoff has passed. The backoff doubles with each consecutive failure past
+// the threshold. It must be called with the mutex held.
+func (m *Managed) openCircuit(now time.Time) {
+	m.disconnect()
+	backoff := m.MinBackoff
+	for i := m.FailureThreshold; i < m.failures && backoff < m.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > m.MaxBackoff {
+		backoff = m.MaxBackoff
+	}
+	m.retryAt = now.Add(backoff)
+	snmpUp.Set(0)
+}
+
+// record updates the health of the connection with the result of a request. A
+// request that was abandoned because its context was done says nothing about
+// the health of the agent, so is ignored.
+func (m *Managed) record(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err == nil {
+		m.failures = 0
+		snmpUp.Set(1)
+		return
+	}
+	m.failures++
+	snmpUp.Set(0)
+	if m.failures >= m.FailureThreshold {
+		slog.Warn("Consecutive SNMP requests failed, reconnecting", "target", m.template.Target, "failures", m.failures, "error", err)
+		m.openCircuit(m.now())
+	}
+}
+
+// Healthy reports whether the last request to the agent succeeded.
+func (m *Managed) Healthy() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.conn != nil && m.failures == 0
+}
+
+// Close closes the connection to the agent.
+func (m *Managed) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.disconnect()
+	return nil
+}
+
+// do makes a request on the current connection, connecting first if needed,
+// and records its result. Only one request is made at a time.
+func (m *Managed) do(ctx context.Context, request func(c conn) error) error {
+	m.requestMutex.Lock()
+	defer m.requestMutex.Unlock()
+	m.mutex.Lock()
+	c, err := m.connect()
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	err = request(c)
+	m.record(ctx, err)
+	return err
+}
+
+// BulkWalkAll performs an SNMP BulkWalk operation for an OID, returning an
+// array of all values. If DisableBulk is set then the OID is walked with
+// GetNext instead.
+func (m *Managed) BulkWalkAll(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error) {
+	if m.DisableBulk {
+		return m.Walk(ctx, rootOid)
+	}
+	err = m.do(ctx, func(c conn) error {
+		results, err = c.BulkWalkAll(ctx, rootOid)
+		return err
+	})
+	return results, err
+}
+
+// Walk performs an SNMP Walk operation for an OID with GetNext requests,
+// returning an array of all values.
+func (m *Managed) Walk(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error) {
+	err = m.do(ctx, func(c conn) error {
+		results, err = c.Walk(ctx, rootOid)
+		return err
+	})
+	return results, err
+}
+
+// Get does an SNMP Get operation on an array of OIDs.
+func (m *Managed) Get(ctx context.Context, oids []string) (result *gosnmp.SnmpPacket, err error) {
+	err = m.do(ctx, func(c conn) error {
+		result, err = c.Get(ctx, oids)
+		return err
+	})
+	return result, err
+}
+
+// GetNext does an SNMP GetNext operation on an array of OIDs.
+func (m *Managed) GetNext(ctx context.Context, oids []string) (result *gosnmp.SnmpPacket, err error) {
+	err = m.do(ctx, func(c conn) error {
+		result, err = c.GetNext(ctx, oids)
+		return err
+	})
+	return result, err
+}
+
+// GetBulk does an SNMP GetBulk operation on an array of OIDs.
+func (m *Managed) GetBulk(ctx context.Context, oids []string, nonRepeaters uint8, maxRepetitions uint32) (result *gosnmp.SnmpPacket, err error) {
+	err = m.do(ctx, func(c conn) error {
+		result, err = c.GetBulk(ctx, oids, nonRepeaters, maxRepetitions)
+		return err
+	})
+	return result, err
+}
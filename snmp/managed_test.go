@@ -0,0 +1,250 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// fakeConn is a connection whose requests fail while err is set.
+type fakeConn struct {
+	SNMP
+	err    *error
+	closed bool
+	walks  int
+}
+
+func (f *fakeConn) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	return nil, *f.err
+}
+
+func (f *fakeConn) Walk(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	f.walks++
+	return nil, *f.err
+}
+
+func (f *fakeConn) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	if *f.err != nil {
+		return nil, *f.err
+	}
+	return &gosnmp.SnmpPacket{}, nil
+}
+
+func (f *fakeConn) Close() error {
+	f.closed = true
+	return nil
+}
+
+// fakeManaged returns a Managed whose connections, clock and name resolution
+// are all controlled by the test.
+type fakeManaged struct {
+	*Managed
+	conns   []*fakeConn
+	dialErr error
+	reqErr  error
+	time    time.Time
+	addrs   []string
+}
+
+func newFakeManaged() *fakeManaged {
+	f := &fakeManaged{
+		Managed: NewManaged(&gosnmp.GoSNMP{Target: "s1-abc0t.measurement-lab.org"}),
+		time:    time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		addrs:   []string{"192.0.2.1"},
+	}
+	f.dial = func() (conn, error) {
+		if f.dialErr != nil {
+			return nil, f.dialErr
+		}
+		c := &fakeConn{err: &f.reqErr}
+		f.conns = append(f.conns, c)
+		return c, nil
+	}
+	f.now = func() time.Time { return f.time }
+	f.lookup = func(host string) ([]string, error) { return f.addrs, nil }
+	return f
+}
+
+func Test_ManagedCircuitBreaker(t *testing.T) {
+	m := newFakeManaged()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Connect() returned an unexpected error: %v", err)
+	}
+	if !m.Healthy() {
+		t.Error("Expected the client to be healthy after connecting")
+	}
+
+	m.reqErr = errors.New("request timeout")
+	for i := 0; i < m.FailureThreshold; i++ {
+		if _, err := m.Get(context.Background(), []string{".1.3"}); err != m.reqErr {
+			t.Errorf("Expected request %v to return the request error, but got: %v", i, err)
+		}
+	}
+	if m.Healthy() {
+		t.Error("Did not expect the client to be healthy after failed requests")
+	}
+	if !m.conns[0].closed {
+		t.Error("Expected the connection to be closed once the threshold was reached")
+	}
+
+	// The circuit is open, so the agent isn't contacted until the backoff has
+	// passed.
+	if _, err := m.Get(context.Background(), []string{".1.3"}); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable while the circuit is open, but got: %v", err)
+	}
+	if len(m.conns) != 1 {
+		t.Errorf("Did not expect a reconnection during the backoff, but got %v connections", len(m.conns))
+	}
+
+	// A reconnection that still fails doubles the backoff.
+	m.time = m.time.Add(m.MinBackoff)
+	m.Get(context.Background(), []string{".1.3"})
+	if len(m.conns) != 2 {
+		t.Fatalf("Expected a reconnection after the backoff, but got %v connections", len(m.conns))
+	}
+	m.time = m.time.Add(m.MinBackoff)
+	if _, err := m.Get(context.Background(), []string{".1.3"}); err != ErrUnavailable {
+		t.Errorf("Expected the backoff to double, but got: %v", err)
+	}
+
+	// Once the agent answers again the client is healthy.
+	m.time = m.time.Add(m.MinBackoff)
+	m.reqErr = nil
+	if _, err := m.Get(context.Background(), []string{".1.3"}); err != nil {
+		t.Errorf("Expected the request to succeed after reconnecting, but got: %v", err)
+	}
+	if !m.Healthy() || len(m.conns) != 3 {
+		t.Errorf("Expected a healthy third connection, but got %v connections", len(m.conns))
+	}
+}
+
+func Test_ManagedCancelled(t *testing.T) {
+	m := newFakeManaged()
+	m.Connect()
+
+	// Requests abandoned by their context don't count towards the threshold.
+	m.reqErr = context.Canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < m.FailureThreshold; i++ {
+		m.Get(ctx, []string{".1.3"})
+	}
+	if !m.Healthy() || m.conns[0].closed {
+		t.Error("Expected cancelled requests not to affect the health of the client")
+	}
+}
+
+// overlapConn counts the most requests it has had in progress at once.
+type overlapConn struct {
+	SNMP
+	active  int32
+	maximum int32
+}
+
+func (o *overlapConn) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	n := atomic.AddInt32(&o.active, 1)
+	defer atomic.AddInt32(&o.active, -1)
+	for {
+		maximum := atomic.LoadInt32(&o.maximum)
+		if n <= maximum || atomic.CompareAndSwapInt32(&o.maximum, maximum, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return &gosnmp.SnmpPacket{}, nil
+}
+
+func (o *overlapConn) Close() error {
+	return nil
+}
+
+func Test_ManagedSerializesRequests(t *testing.T) {
+	m := newFakeManaged()
+	o := &overlapConn{}
+	m.dial = func() (conn, error) { return o, nil }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Get(context.Background(), []string{".1.3"})
+		}()
+	}
+	wg.Wait()
+	if o.maximum != 1 {
+		t.Errorf("Expected one request at a time, but %v were made at once", o.maximum)
+	}
+}
+
+func Test_ManagedDisableBulk(t *testing.T) {
+	m := newFakeManaged()
+	m.Connect()
+	m.BulkWalkAll(context.Background(), ".1.3")
+	if m.conns[0].walks != 0 {
+		t.Error("Did not expect a walk with GetNext while GetBulk is enabled")
+	}
+	m.DisableBulk = true
+	m.BulkWalkAll(context.Background(), ".1.3")
+	if m.conns[0].walks != 1 {
+		t.Error("Expected a walk with GetNext while GetBulk is disabled")
+	}
+}
+
+func Test_ManagedDialFailure(t *testing.T) {
+	m := newFakeManaged()
+	m.dialErr = errors.New("no route to host")
+	if err := m.Connect(); err != m.dialErr {
+		t.Errorf("Expected Connect() to return the dial error, but got: %v", err)
+	}
+	if _, err := m.Get(context.Background(), []string{".1.3"}); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable after a failed dial, but got: %v", err)
+	}
+
+	m.dialErr = nil
+	m.time = m.time.Add(m.MinBackoff)
+	if _, err := m.Get(context.Background(), []string{".1.3"}); err != nil {
+		t.Errorf("Expected the request to succeed after the backoff, but got: %v", err)
+	}
+}
+
+func Test_ManagedMaxBackoff(t *testing.T) {
+	m := newFakeManaged()
+	m.dialErr = errors.New("no route to host")
+	for i := 0; i < 20; i++ {
+		m.Connect()
+		m.time = m.retryAt
+	}
+	m.Connect()
+	if backoff := m.retryAt.Sub(m.time); backoff != m.MaxBackoff {
+		t.Errorf("Expected the backoff to be capped at %v, but got: %v", m.MaxBackoff, backoff)
+	}
+}
+
+func Test_ManagedResolve(t *testing.T) {
+	m := newFakeManaged()
+	m.Connect()
+
+	m.time = m.time.Add(m.ResolveInterval)
+	m.Get(context.Background(), []string{".1.3"})
+	if len(m.conns) != 1 {
+		t.Errorf("Did not expect a reconnection when the addresses are unchanged, but got %v connections", len(m.conns))
+	}
+
+	m.addrs = []string{"192.0.2.2"}
+	m.time = m.time.Add(m.ResolveInterval / 2)
+	m.Get(context.Background(), []string{".1.3"})
+	if len(m.conns) != 1 {
+		t.Errorf("Did not expect the target to be resolved before the interval, but got %v connections", len(m.conns))
+	}
+	m.time = m.time.Add(m.ResolveInterval / 2)
+	m.Get(context.Background(), []string{".1.3"})
+	if len(m.conns) != 2 || !m.conns[0].closed {
+		t.Errorf("Expected a reconnection when the addresses changed, but got %v connections", len(m.conns))
+	}
+}
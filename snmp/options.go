@@ -0,0 +1,110 @@
+package snmp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Options configures the transport used to reach an SNMP agent.
+type Options struct {
+	Port               uint
+	Timeout            time.Duration
+	Retries            int
+	ExponentialTimeout bool
+	// Transport is one of udp, udp4, udp6, tcp, tcp4 or tcp6.
+	Transport string
+	// SourceAddress is the local IP address to send requests from. If empty
+	// the operating system chooses one.
+	SourceAddress string
+	// Version is the version of SNMP used with a community, "1" or "2c".
+	// SNMPv3 is used instead whenever SNMPv3 credentials are given.
+	Version string
+}
+
+// DefaultOptions are the options used when none are given.
+var DefaultOptions = Options{
+	Port:      161,
+	Timeout:   2 * time.Second,
+	Retries:   1,
+	Transport: "udp",
+	Version:   "2c",
+}
+
+// Validate checks that the options are usable, returning an error describing
+// the first problem found.
+func (o Options) Validate() error {
+	if o.Port == 0 || o.Port > 65535 {
+		return fmt.Errorf("SNMP port %v is not between 1 and 65535", o.Port)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("SNMP timeout %v must be positive", o.Timeout)
+	}
+	if o.Retries < 0 {
+		return fmt.Errorf("SNMP retries %v must not be negative", o.Retries)
+	}
+	switch o.Transport {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("unknown SNMP transport '%v', must be one of udp, udp4, udp6, tcp, tcp4 or tcp6", o.Transport)
+	}
+	if o.Version != "1" && o.Version != "2c" {
+		return fmt.Errorf("unknown SNMP version '%v', must be 1 or 2c", o.Version)
+	}
+	if o.SourceAddress != "" {
+		ip := net.ParseIP(o.SourceAddress)
+		if ip == nil {
+			return fmt.Errorf("SNMP source address '%v' is not an IP address", o.SourceAddress)
+		}
+		isV4 := ip.To4() != nil
+		if (strings.HasSuffix(o.Transport, "4") && !isV4) || (strings.HasSuffix(o.Transport, "6") && isV4) {
+			return fmt.Errorf("SNMP source address '%v' does not match transport %v", o.SourceAddress, o.Transport)
+		}
+	}
+	return nil
+}
+
+// GoSNMP returns an unconnected gosnmp client for target, configured with the
+// options. The source address and credentials are applied when a Managed client
+// connects.
+func (o Options) GoSNMP(target string) *gosnmp.GoSNMP {
+	version := gosnmp.Version2c
+	if o.Version == "1" {
+		version = gosnmp.Version1
+	}
+	return &gosnmp.GoSNMP{
+		Target:             target,
+		Port:               uint16(o.Port),
+		Transport:          o.Transport,
+		Version:            version,
+		Timeout:            o.Timeout,
+		Retries:            o.Retries,
+		ExponentialTimeout: o.ExponentialTimeout,
+	}
+}
+
+// bind replaces the connection of a connected gosnmp client with one sent from
+// the source address. gosnmp has no way to choose the local address of the
+// connections it makes itself.
+func bind(g *gosnmp.GoSNMP, source string) error {
+	var local net.Addr
+	ip := net.ParseIP(source)
+	if strings.HasPrefix(g.Transport, "tcp") {
+		local = &net.TCPAddr{IP: ip}
+	} else {
+		local = &net.UDPAddr{IP: ip}
+	}
+	dialer := net.Dialer{Timeout: g.Timeout, LocalAddr: local}
+	addr := net.JoinHostPort(g.Target, strconv.Itoa(int(g.Port)))
+	c, err := dialer.DialContext(g.Context, g.Transport, addr)
+	if err != nil {
+		return err
+	}
+	g.Conn.Close()
+	g.Conn = c
+	return nil
+}
@@ -0,0 +1,127 @@
+package snmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+func Test_OptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			modify: func(o *Options) {},
+		},
+		{
+			name:   "tcp6-with-source",
+			modify: func(o *Options) { o.Transport = "tcp6"; o.SourceAddress = "2001:db8::1" },
+		},
+		{
+			name:   "v1",
+			modify: func(o *Options) { o.Version = "1" },
+		},
+		{
+			name:    "zero-port",
+			modify:  func(o *Options) { o.Port = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "large-port",
+			modify:  func(o *Options) { o.Port = 65536 },
+			wantErr: true,
+		},
+		{
+			name:    "zero-timeout",
+			modify:  func(o *Options) { o.Timeout = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "negative-retries",
+			modify:  func(o *Options) { o.Retries = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "unknown-transport",
+			modify:  func(o *Options) { o.Transport = "sctp" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown-version",
+			modify:  func(o *Options) { o.Version = "3" },
+			wantErr: true,
+		},
+		{
+			name:    "source-not-an-ip",
+			modify:  func(o *Options) { o.SourceAddress = "localhost" },
+			wantErr: true,
+		},
+		{
+			name:    "source-wrong-family",
+			modify:  func(o *Options) { o.Transport = "udp6"; o.SourceAddress = "192.0.2.1" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		o := DefaultOptions
+		tt.modify(&o)
+		err := o.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_OptionsGoSNMP(t *testing.T) {
+	o := Options{
+		Port:               1161,
+		Timeout:            5 * time.Second,
+		Retries:            3,
+		ExponentialTimeout: true,
+		Transport:          "tcp",
+	}
+	g := o.GoSNMP("s1-abc0t.measurement-lab.org")
+	if g.Target != "s1-abc0t.measurement-lab.org" || g.Port != 1161 || g.Timeout != 5*time.Second ||
+		g.Retries != 3 || !g.ExponentialTimeout || g.Transport != "tcp" {
+		t.Errorf("GoSNMP() did not apply the options, got: %+v", g)
+	}
+	if g.Version != gosnmp.Version2c {
+		t.Errorf("Expected SNMPv2c by default, but got: %v", g.Version)
+	}
+	o.Version = "1"
+	if g = o.GoSNMP("s1-abc0t.measurement-lab.org"); g.Version != gosnmp.Version1 {
+		t.Errorf("Expected SNMPv1, but got: %v", g.Version)
+	}
+}
+
+func Test_ManagedSourceAddress(t *testing.T) {
+	agent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer agent.Close()
+
+	o := DefaultOptions
+	o.Port = uint(agent.LocalAddr().(*net.UDPAddr).Port)
+	o.SourceAddress = "127.0.0.1"
+	g := o.GoSNMP("127.0.0.1")
+	g.Community = "snmp-community"
+	m := NewManaged(g)
+	m.SourceAddress = o.SourceAddress
+	err = m.Connect()
+	if err != nil {
+		t.Fatalf("Connect() returned an unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	local := m.conn.(*RealSNMP).GoSNMP.Conn.LocalAddr().(*net.UDPAddr)
+	if !local.IP.Equal(net.ParseIP(o.SourceAddress)) {
+		t.Errorf("Expected the connection to be from %v, but got: %v", o.SourceAddress, local.IP)
+	}
+}
@@ -0,0 +1,77 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/soniah/gosnmp"
+)
+
+const (
+	systemOid      = ".1.3.6.1.2.1.1"
+	sysObjectIDOid = ".1.3.6.1.2.1.1.2.0"
+)
+
+var snmpCapability = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "disco_snmp_capability",
+		Help: "Whether the switch supports an SNMP operation (1) or not (0), as probed at startup.",
+	},
+	[]string{"operation"},
+)
+
+// Capabilities are the SNMP operations an agent was found to support.
+type Capabilities struct {
+	Get     bool
+	GetNext bool
+	GetBulk bool
+}
+
+func (c Capabilities) String() string {
+	return fmt.Sprintf("Get: %v, GetNext: %v, GetBulk: %v", c.Get, c.GetNext, c.GetBulk)
+}
+
+// Probe finds which operations the agent behind s supports by reading its
+// system group with a Get, a GetNext and a GetBulk request. Probing only reads
+// from the agent. The result is exported as the disco_snmp_capability metric.
+// An error is returned if the agent doesn't answer a Get, since then nothing
+// else can be expected to work either.
+func Probe(ctx context.Context, s SNMP) (Capabilities, error) {
+	caps := Capabilities{}
+
+	packet, err := s.Get(ctx, []string{sysObjectIDOid})
+	if !answered(packet, err) {
+		if err == nil {
+			err = fmt.Errorf("no valid response")
+		}
+		return caps, fmt.Errorf("the agent did not answer a Get of sysObjectID: %v", err)
+	}
+	caps.Get = true
+
+	packet, err = s.GetNext(ctx, []string{systemOid})
+	caps.GetNext = answered(packet, err) && packet.Variables[0].Type != gosnmp.EndOfMibView
+
+	packet, err = s.GetBulk(ctx, []string{systemOid}, 0, 2)
+	caps.GetBulk = answered(packet, err) && packet.Variables[0].Type != gosnmp.EndOfMibView
+
+	for operation, supported := range map[string]bool{
+		"get":     caps.Get,
+		"getnext": caps.GetNext,
+		"getbulk": caps.GetBulk,
+	} {
+		value := 0.0
+		if supported {
+			value = 1
+		}
+		snmpCapability.WithLabelValues(operation).Set(value)
+	}
+	return caps, nil
+}
+
+// answered reports whether a request was answered without an error-status and
+// with at least one variable.
+func answered(packet *gosnmp.SnmpPacket, err error) bool {
+	return err == nil && packet != nil && packet.Error == gosnmp.NoError && len(packet.Variables) > 0
+}
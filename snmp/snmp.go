@@ -1,13 +1,20 @@
 package snmp
 
 import (
+	"context"
+	"time"
+
 	"github.com/soniah/gosnmp"
 )
 
-// SNMP defines a new SNMP interface to abstract SNMP operations.
+// SNMP defines a new SNMP interface to abstract SNMP operations. Each operation
+// is abandoned, returning the error of ctx, once ctx is done.
 type SNMP interface {
-	BulkWalkAll(rootOid string) (results []gosnmp.SnmpPDU, err error)
-	Get(oids []string) (result *gosnmp.SnmpPacket, err error)
+	BulkWalkAll(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error)
+	Get(ctx context.Context, oids []string) (result *gosnmp.SnmpPacket, err error)
+	GetBulk(ctx context.Context, oids []string, nonRepeaters uint8, maxRepetitions uint32) (result *gosnmp.SnmpPacket, err error)
+	GetNext(ctx context.Context, oids []string) (result *gosnmp.SnmpPacket, err error)
+	Walk(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error)
 }
 
 // RealSNMP implements the SNMP interface.
@@ -16,14 +23,87 @@ type RealSNMP struct {
 }
 
 // BulkWalkAll performs an SNMP BulkWalk operation for an OID, returning an
-// array of all values.
-func (s *RealSNMP) BulkWalkAll(rootOid string) (results []gosnmp.SnmpPDU, err error) {
-	return s.GoSNMP.BulkWalkAll(rootOid)
+// array of all values. SNMPv1 has no GetBulk, so v1 agents are walked with
+// GetNext instead.
+func (s *RealSNMP) BulkWalkAll(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error) {
+	if s.GoSNMP.Version == gosnmp.Version1 {
+		return s.Walk(ctx, rootOid)
+	}
+	defer s.withContext(ctx)()
+	results, err = s.GoSNMP.BulkWalkAll(rootOid)
+	return results, contextErr(ctx, err)
+}
+
+// Walk performs an SNMP Walk operation for an OID with GetNext requests,
+// returning an array of all values.
+func (s *RealSNMP) Walk(ctx context.Context, rootOid string) (results []gosnmp.SnmpPDU, err error) {
+	defer s.withContext(ctx)()
+	results, err = s.GoSNMP.WalkAll(rootOid)
+	return results, contextErr(ctx, err)
 }
 
 // Get does an SNMP Get operation on an array of OIDs.
-func (s *RealSNMP) Get(oids []string) (results *gosnmp.SnmpPacket, err error) {
-	return s.GoSNMP.Get(oids)
+func (s *RealSNMP) Get(ctx context.Context, oids []string) (results *gosnmp.SnmpPacket, err error) {
+	defer s.withContext(ctx)()
+	results, err = s.GoSNMP.Get(oids)
+	return results, contextErr(ctx, err)
+}
+
+// GetNext does an SNMP GetNext operation on an array of OIDs.
+func (s *RealSNMP) GetNext(ctx context.Context, oids []string) (results *gosnmp.SnmpPacket, err error) {
+	defer s.withContext(ctx)()
+	results, err = s.GoSNMP.GetNext(oids)
+	return results, contextErr(ctx, err)
+}
+
+// GetBulk does an SNMP GetBulk operation on an array of OIDs. It fails for
+// SNMPv1 agents.
+func (s *RealSNMP) GetBulk(ctx context.Context, oids []string, nonRepeaters uint8, maxRepetitions uint32) (results *gosnmp.SnmpPacket, err error) {
+	defer s.withContext(ctx)()
+	results, err = s.GoSNMP.GetBulk(oids, nonRepeaters, maxRepetitions)
+	return results, contextErr(ctx, err)
+}
+
+// withContext makes requests use ctx until the returned function is called.
+// gosnmp checks the context between retries and shortens the read deadline to
+// that of the context, but doesn't notice a cancellation while it waits for a
+// response, so the deadline of the connection is also brought forward when ctx
+// is done. The returned function waits for the goroutine doing that to finish
+// and clears any deadline it set, so that it can't fail the next request.
+func (s *RealSNMP) withContext(ctx context.Context) func() {
+	s.GoSNMP.Context = ctx
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			s.GoSNMP.Conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if ctx.Err() != nil {
+			s.GoSNMP.Conn.SetDeadline(time.Time{})
+		}
+		s.GoSNMP.Context = context.Background()
+	}
+}
+
+// contextErr returns the error of ctx in place of err if ctx is done, since a
+// request aborted by the context fails with a less useful timeout error.
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Close closes the connection to the SNMP agent.
+func (s *RealSNMP) Close() error {
+	return s.GoSNMP.Conn.Close()
 }
 
 // Client returns a new RealSNMP object.
@@ -0,0 +1,320 @@
+package snmp
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Fixtures are stored in the snmprec format used by snmpsim: one variable per
+// line as "OID|TAG|VALUE", where TAG is the numeric ASN.1 type of the variable.
+// A TAG with an "x" suffix holds a hex encoded value, which is used for octet
+// strings that are not printable. Blank lines and lines starting with "#" are
+// ignored.
+
+// Record walks each of the roots on s and writes every variable found to w in
+// snmprec format, in OID order and without duplicates, returning the number of
+// variables written. Variables of types that can't be replayed, such as
+// NoSuchObject, are skipped.
+func Record(ctx context.Context, s SNMP, w io.Writer, roots []string) (int, error) {
+	pdus := make(map[string]gosnmp.SnmpPDU)
+	for _, root := range roots {
+		results, err := s.BulkWalkAll(ctx, root)
+		if err != nil {
+			return 0, fmt.Errorf("failed to walk %v: %v", root, err)
+		}
+		for _, pdu := range results {
+			pdus[normalizeOID(pdu.Name)] = pdu
+		}
+	}
+
+	names := []string{}
+	for name := range pdus {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return oidLess(names[i], names[j]) })
+
+	buf := bufio.NewWriter(w)
+	n := 0
+	for _, name := range names {
+		line, ok := formatRecord(pdus[name])
+		if !ok {
+			continue
+		}
+		fmt.Fprintln(buf, line)
+		n++
+	}
+	return n, buf.Flush()
+}
+
+// formatRecord returns the snmprec line for a variable, or false if its type
+// isn't supported.
+func formatRecord(pdu gosnmp.SnmpPDU) (string, bool) {
+	oid := strings.TrimPrefix(pdu.Name, ".")
+	tag := strconv.Itoa(int(pdu.Type))
+	var value string
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		b, _ := pdu.Value.([]byte)
+		if !printable(b) {
+			return fmt.Sprintf("%v|%vx|%v", oid, tag, hex.EncodeToString(b)), true
+		}
+		value = string(b)
+	case gosnmp.ObjectIdentifier:
+		value = strings.TrimPrefix(fmt.Sprint(pdu.Value), ".")
+	case gosnmp.IPAddress, gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64:
+		value = fmt.Sprint(pdu.Value)
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%v|%v|%v", oid, tag, value), true
+}
+
+// printable reports whether b is text that can be stored in a fixture as is.
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	return strings.IndexFunc(string(b), func(r rune) bool { return !unicode.IsPrint(r) }) == -1
+}
+
+// Replay implements the SNMP interface by serving variables recorded from an
+// agent, so that the collector can be run against a switch offline. Values
+// have the same Go types as those decoded by gosnmp.
+type Replay struct {
+	pdus   map[string]gosnmp.SnmpPDU
+	sorted []string
+}
+
+// LoadReplay returns a Replay serving the fixture in the snmprec file at path.
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := NewReplay(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return r, nil
+}
+
+// NewReplay returns a Replay serving the fixture in snmprec format read from r.
+func NewReplay(r io.Reader) (*Replay, error) {
+	replay := &Replay{
+		pdus: make(map[string]gosnmp.SnmpPDU),
+	}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pdu, err := parseRecord(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		if _, ok := replay.pdus[pdu.Name]; !ok {
+			replay.sorted = append(replay.sorted, pdu.Name)
+		}
+		replay.pdus[pdu.Name] = pdu
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(replay.sorted, func(i, j int) bool { return oidLess(replay.sorted[i], replay.sorted[j]) })
+	return replay, nil
+}
+
+// parseRecord parses a single snmprec line.
+func parseRecord(text string) (gosnmp.SnmpPDU, error) {
+	fields := strings.SplitN(text, "|", 3)
+	if len(fields) != 3 {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("expected OID|TAG|VALUE")
+	}
+	oid, tag, value := fields[0], fields[1], fields[2]
+	if oid == "" {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("missing OID")
+	}
+	pdu := gosnmp.SnmpPDU{Name: normalizeOID(oid)}
+
+	hexValue := strings.HasSuffix(tag, "x")
+	n, err := strconv.Atoi(strings.TrimSuffix(tag, "x"))
+	if err != nil {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("malformed tag '%v'", tag)
+	}
+	pdu.Type = gosnmp.Asn1BER(n)
+	if hexValue {
+		if pdu.Type != gosnmp.OctetString {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("hex values are only supported for octet strings")
+		}
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("malformed hex value: %v", err)
+		}
+		pdu.Value = b
+		return pdu, nil
+	}
+
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		pdu.Value = []byte(value)
+	case gosnmp.ObjectIdentifier:
+		pdu.Value = normalizeOID(value)
+	case gosnmp.IPAddress:
+		pdu.Value = value
+	case gosnmp.Integer:
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("malformed Integer '%v'", value)
+		}
+		pdu.Value = int(i)
+	case gosnmp.Counter32, gosnmp.Gauge32:
+		u, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("malformed %v '%v'", pdu.Type, value)
+		}
+		pdu.Value = uint(u)
+	case gosnmp.TimeTicks:
+		u, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("malformed TimeTicks '%v'", value)
+		}
+		pdu.Value = uint32(u)
+	case gosnmp.Counter64:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("malformed Counter64 '%v'", value)
+		}
+		pdu.Value = u
+	default:
+		return gosnmp.SnmpPDU{}, fmt.Errorf("unsupported tag '%v'", tag)
+	}
+	return pdu, nil
+}
+
+// BulkWalkAll returns every recorded variable in the subtree of rootOid, in
+// OID order. As with gosnmp, walking a leaf returns the leaf itself.
+func (r *Replay) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	root := normalizeOID(rootOid)
+	results := []gosnmp.SnmpPDU{}
+	for _, name := range r.sorted {
+		if strings.HasPrefix(name, root+".") {
+			results = append(results, r.pdus[name])
+		}
+	}
+	if pdu, ok := r.pdus[root]; ok && len(results) == 0 {
+		results = append(results, pdu)
+	}
+	return results, nil
+}
+
+// Get returns the recorded variables for oids, with a NoSuchObject value for
+// any that weren't recorded.
+func (r *Replay) Get(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	packet := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		PDUType:   gosnmp.GetResponse,
+		Variables: []gosnmp.SnmpPDU{},
+	}
+	for _, oid := range oids {
+		pdu, ok := r.pdus[normalizeOID(oid)]
+		if !ok {
+			pdu = gosnmp.SnmpPDU{Name: normalizeOID(oid), Type: gosnmp.NoSuchObject}
+		}
+		packet.Variables = append(packet.Variables, pdu)
+	}
+	return packet, nil
+}
+
+// Walk returns every recorded variable in the subtree of rootOid, in OID
+// order, as BulkWalkAll does.
+func (r *Replay) Walk(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	return r.BulkWalkAll(ctx, rootOid)
+}
+
+// GetNext returns the first recorded variable after each of oids, with an
+// EndOfMibView value for any that have none.
+func (r *Replay) GetNext(ctx context.Context, oids []string) (*gosnmp.SnmpPacket, error) {
+	return r.GetBulk(ctx, oids, uint8(len(oids)), 0)
+}
+
+// GetBulk returns the first recorded variable after each of the first
+// nonRepeaters oids, followed by up to maxRepetitions successive variables
+// after each of the rest, interleaved as an agent returns them.
+func (r *Replay) GetBulk(ctx context.Context, oids []string, nonRepeaters uint8, maxRepetitions uint32) (*gosnmp.SnmpPacket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	packet := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		PDUType:   gosnmp.GetResponse,
+		Variables: []gosnmp.SnmpPDU{},
+	}
+	n := int(nonRepeaters)
+	if n > len(oids) {
+		n = len(oids)
+	}
+	for _, oid := range oids[:n] {
+		packet.Variables = append(packet.Variables, r.next(oid))
+	}
+	repeaters := append([]string{}, oids[n:]...)
+	for i := uint32(0); i < maxRepetitions && len(repeaters) > 0; i++ {
+		for j, oid := range repeaters {
+			pdu := r.next(oid)
+			packet.Variables = append(packet.Variables, pdu)
+			repeaters[j] = pdu.Name
+		}
+	}
+	return packet, nil
+}
+
+// next returns the first recorded variable after oid, or an EndOfMibView
+// value if there is none.
+func (r *Replay) next(oid string) gosnmp.SnmpPDU {
+	oid = normalizeOID(oid)
+	i := sort.Search(len(r.sorted), func(i int) bool { return oidLess(oid, r.sorted[i]) })
+	if i == len(r.sorted) {
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+	}
+	return r.pdus[r.sorted[i]]
+}
+
+// normalizeOID returns an OID with a leading dot, as gosnmp names variables.
+func normalizeOID(oid string) string {
+	if strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
+}
+
+// oidLess reports whether OID a sorts before OID b.
+func oidLess(a string, b string) bool {
+	pa := strings.Split(strings.TrimPrefix(a, "."), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, _ := strconv.Atoi(pa[i])
+		nb, _ := strconv.Atoi(pb[i])
+		if na != nb {
+			return na < nb
+		}
+	}
+	return len(pa) < len(pb)
+}
@@ -0,0 +1,166 @@
+package snmp
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soniah/gosnmp"
+)
+
+const fixture = `# A fixture with one variable of each supported type.
+1.3.6.1.2.1.1.1.0|4|Juniper Networks, Inc. qfx5100-48s-6q
+1.3.6.1.2.1.1.2.0|6|1.3.6.1.4.1.2636.1.1.1.2.82
+1.3.6.1.2.1.1.3.0|67|123456
+1.3.6.1.2.1.2.2.1.2.9|4|xe-0/0/1
+1.3.6.1.2.1.2.2.1.2.10|4|xe-0/0/2
+1.3.6.1.2.1.2.2.1.5.9|66|4294967295
+1.3.6.1.2.1.2.2.1.7.9|2|1
+1.3.6.1.2.1.2.2.1.19.9|65|42
+1.3.6.1.2.1.4.20.1.1.10.0.0.1|64|10.0.0.1
+1.3.6.1.2.1.31.1.1.1.6.9|70|18446744073709551615
+1.3.6.1.2.1.31.1.1.1.18.9|4x|00ff
+`
+
+// walker serves a fixed list of variables to any walk.
+type walker struct {
+	SNMP
+	pdus []gosnmp.SnmpPDU
+}
+
+func (w *walker) BulkWalkAll(ctx context.Context, rootOid string) ([]gosnmp.SnmpPDU, error) {
+	results := []gosnmp.SnmpPDU{}
+	for _, pdu := range w.pdus {
+		if strings.HasPrefix(pdu.Name, rootOid+".") {
+			results = append(results, pdu)
+		}
+	}
+	return results, nil
+}
+
+func Test_Replay(t *testing.T) {
+	r, err := NewReplay(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+
+	packet, err := r.Get(context.Background(), []string{".1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.2.2.1.19.9", ".1.3.6.1.2.1.1.5.0"})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	want := []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(123456)},
+		{Name: ".1.3.6.1.2.1.2.2.1.19.9", Type: gosnmp.Counter32, Value: uint(42)},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.NoSuchObject},
+	}
+	if !reflect.DeepEqual(packet.Variables, want) {
+		t.Errorf("Get() = %v, want %v", packet.Variables, want)
+	}
+
+	pdus, _ := r.BulkWalkAll(context.Background(), ".1.3.6.1.2.1.2.2.1.2")
+	want = []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.9", Type: gosnmp.OctetString, Value: []byte("xe-0/0/1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.10", Type: gosnmp.OctetString, Value: []byte("xe-0/0/2")},
+	}
+	if !reflect.DeepEqual(pdus, want) {
+		t.Errorf("BulkWalkAll() = %v, want %v", pdus, want)
+	}
+
+	pdus, _ = r.BulkWalkAll(context.Background(), ".1.3.6.1.2.1.31.1.1.1.18")
+	if len(pdus) != 1 || !bytes.Equal(pdus[0].Value.([]byte), []byte{0x00, 0xff}) {
+		t.Errorf("BulkWalkAll() of a hex value = %v", pdus)
+	}
+	pdus, _ = r.BulkWalkAll(context.Background(), ".1.3.6.1.2.1.1.3.0")
+	if len(pdus) != 1 || pdus[0].Value != uint32(123456) {
+		t.Errorf("BulkWalkAll() of a leaf = %v", pdus)
+	}
+	pdus, _ = r.BulkWalkAll(context.Background(), ".1.3.6.1.2.1.99")
+	if len(pdus) != 0 {
+		t.Errorf("BulkWalkAll() of a missing subtree = %v", pdus)
+	}
+}
+
+func Test_ReplayGetNextAndBulk(t *testing.T) {
+	r, err := NewReplay(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+
+	names := func(packet *gosnmp.SnmpPacket) []string {
+		n := []string{}
+		for _, pdu := range packet.Variables {
+			n = append(n, pdu.Name)
+		}
+		return n
+	}
+	packet, _ := r.GetNext(context.Background(), []string{".1.3.6.1.2.1.1", ".1.3.6.1.2.1.2.2.1.2.9", ".1.4"})
+	want := []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.2.2.1.2.10", ".1.4"}
+	if !reflect.DeepEqual(names(packet), want) || packet.Variables[2].Type != gosnmp.EndOfMibView {
+		t.Errorf("GetNext() = %v, want %v ending with EndOfMibView", packet.Variables, want)
+	}
+
+	packet, _ = r.GetBulk(context.Background(), []string{".1.3.6.1.2.1.1", ".1.3.6.1.2.1.2.2.1.2", ".1.3.6.1.2.1.31.1.1.1.6"}, 1, 2)
+	want = []string{
+		".1.3.6.1.2.1.1.1.0",
+		".1.3.6.1.2.1.2.2.1.2.9", ".1.3.6.1.2.1.31.1.1.1.6.9",
+		".1.3.6.1.2.1.2.2.1.2.10", ".1.3.6.1.2.1.31.1.1.1.18.9",
+	}
+	if !reflect.DeepEqual(names(packet), want) {
+		t.Errorf("GetBulk() = %v, want %v", names(packet), want)
+	}
+}
+
+func Test_ProbeReplay(t *testing.T) {
+	r, err := NewReplay(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+	caps, err := Probe(context.Background(), r)
+	if err != nil || caps != (Capabilities{Get: true, GetNext: true, GetBulk: true}) {
+		t.Errorf("Probe() = %v, %v", caps, err)
+	}
+}
+
+func Test_NewReplayErrors(t *testing.T) {
+	tests := []string{
+		"1.3.6.1.2.1.1.3.0|67",
+		"|4|value",
+		"1.3.6.1.2.1.1.3.0|time|1",
+		"1.3.6.1.2.1.1.3.0|67|-1",
+		"1.3.6.1.2.1.1.3.0|2|one",
+		"1.3.6.1.2.1.1.3.0|4x|zz",
+		"1.3.6.1.2.1.1.3.0|2x|00",
+		"1.3.6.1.2.1.1.3.0|68|00",
+	}
+	for _, tt := range tests {
+		_, err := NewReplay(strings.NewReader("1.3.6.1.2.1.1.1.0|4|ok\n" + tt + "\n"))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("NewReplay(%q) error = %v, want an error on line 2", tt, err)
+		}
+	}
+}
+
+func Test_RecordRoundTrip(t *testing.T) {
+	r, err := NewReplay(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("NewReplay() error = %v", err)
+	}
+
+	// Overlapping roots are recorded once, and unsupported types are skipped.
+	pdus, _ := r.BulkWalkAll(context.Background(), ".1")
+	w := &walker{pdus: append(pdus, gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.99.0", Type: gosnmp.NoSuchObject})}
+	buf := &bytes.Buffer{}
+	n, err := Record(context.Background(), w, buf, []string{".1.3", ".1.3.6.1.2.1.2"})
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if n != 11 {
+		t.Errorf("Record() = %v, want 11", n)
+	}
+	want := strings.SplitN(fixture, "\n", 2)[1]
+	if buf.String() != want {
+		t.Errorf("Record() wrote:\n%v\nwant:\n%v", buf.String(), want)
+	}
+}
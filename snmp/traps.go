@@ -0,0 +1,262 @@
+package snmp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/soniah/gosnmp"
+)
+
+const (
+	// snmpTrapOid is the variable of a notification naming its type.
+	snmpTrapOid = ".1.3.6.1.6.3.1.1.4.1.0"
+	// usmStatsUnknownEngineIDs is reported to SNMPv3 requests for another
+	// engine, which is how agents discover the engine ID of a receiver.
+	usmStatsUnknownEngineIDs = ".1.3.6.1.6.3.15.1.1.4.0"
+)
+
+var notificationsRejected = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "disco_snmp_notifications_rejected_total",
+		Help: "The number of SNMP notifications dropped because they were malformed or had the wrong credentials.",
+	},
+)
+
+// Notification is an SNMPv2c or SNMPv3 trap or inform received from an agent.
+type Notification struct {
+	Source    net.IP
+	Inform    bool
+	TrapOID   string
+	Variables []gosnmp.SnmpPDU
+}
+
+// TrapReceiver listens for traps and informs sent by agents with the current
+// credentials, and passes them to a handler. Informs are acknowledged once
+// handled. The receiver is the authoritative SNMPv3 engine for informs, which
+// agents discover as they do the engine of an agent they poll, while SNMPv3
+// traps are accepted from any engine. SNMPv1 traps are not supported.
+type TrapReceiver struct {
+	// EngineID is the SNMPv3 engine ID of the receiver.
+	EngineID string
+
+	credentials func() (Credentials, error)
+	handler     func(Notification)
+	conn        *net.UDPConn
+	start       time.Time
+	done        chan struct{}
+}
+
+// NewTrapReceiver starts a TrapReceiver listening on the UDP address, which
+// accepts notifications sent with the credentials returned by credentials and
+// passes them to handler.
+func NewTrapReceiver(address string, credentials func() (Credentials, error), handler func(Notification)) (*TrapReceiver, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	r := &TrapReceiver{
+		EngineID:    "\x80\x00\x1f\x88\x04disco-traps",
+		credentials: credentials,
+		handler:     handler,
+		conn:        conn,
+		start:       time.Now(),
+		done:        make(chan struct{}),
+	}
+	go r.serve()
+	return r, nil
+}
+
+// Addr returns the address the receiver is listening on.
+func (r *TrapReceiver) Addr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close stops the receiver.
+func (r *TrapReceiver) Close() error {
+	err := r.conn.Close()
+	<-r.done
+	return err
+}
+
+func (r *TrapReceiver) serve() {
+	defer close(r.done)
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		resp := r.receive(buf[:n], from)
+		if resp != nil {
+			r.conn.WriteToUDP(resp, from)
+		}
+	}
+}
+
+// receive handles a single message, returning the response to send, if any.
+func (r *TrapReceiver) receive(msg []byte, from *net.UDPAddr) []byte {
+	creds, err := r.credentials()
+	if err != nil {
+		slog.Warn("Dropped an SNMP notification", "source", from.IP, "error", err)
+		notificationsRejected.Inc()
+		return nil
+	}
+	x := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	creds.apply(x)
+	if usm, ok := x.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+		usm.AuthoritativeEngineID = r.EngineID
+	}
+
+	packet := decodeNotification(x, msg)
+	if packet == nil || !r.authorized(x, packet) {
+		notificationsRejected.Inc()
+		return nil
+	}
+	if packet.Version == gosnmp.Version3 && packet.MsgFlags&gosnmp.Reportable != 0 &&
+		packet.SecurityParameters.(*gosnmp.UsmSecurityParameters).AuthoritativeEngineID != r.EngineID {
+		return r.report(packet)
+	}
+	if packet.PDUType != gosnmp.SNMPv2Trap && packet.PDUType != gosnmp.InformRequest {
+		notificationsRejected.Inc()
+		return nil
+	}
+
+	n := Notification{
+		Source:    from.IP,
+		Inform:    packet.PDUType == gosnmp.InformRequest,
+		Variables: packet.Variables,
+	}
+	for _, pdu := range packet.Variables {
+		if normalizeOID(pdu.Name) == snmpTrapOid {
+			n.TrapOID, _ = pdu.Value.(string)
+		}
+	}
+	if n.TrapOID == "" {
+		notificationsRejected.Inc()
+		return nil
+	}
+	r.handler(n)
+
+	if !n.Inform {
+		return nil
+	}
+	return r.acknowledge(x, packet)
+}
+
+// decodeNotification decodes and, for SNMPv3, authenticates and decrypts a
+// message with the credentials set on x. It returns nil if the message can't
+// be decoded.
+func decodeNotification(x *gosnmp.GoSNMP, msg []byte) (packet *gosnmp.SnmpPacket) {
+	// gosnmp panics when checking the digest of an authenticated message
+	// against a user without an authentication protocol.
+	defer func() {
+		if recover() != nil {
+			packet = nil
+		}
+	}()
+	// Decoding blanks the digest in the message, so a copy is decoded.
+	return x.UnmarshalTrap(append([]byte{}, msg...), true)
+}
+
+// authorized reports whether a decoded message was sent with the credentials
+// set on x: the same community, or the same SNMPv3 user at the same security
+// level. SNMPv3 discovery requests, which have no user, are always authorized.
+func (r *TrapReceiver) authorized(x *gosnmp.GoSNMP, packet *gosnmp.SnmpPacket) bool {
+	if packet.Version != x.Version {
+		return false
+	}
+	if packet.Version != gosnmp.Version3 {
+		return packet.Community == x.Community
+	}
+	usm := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if usm.UserName == "" && packet.MsgFlags&gosnmp.AuthPriv == gosnmp.NoAuthNoPriv &&
+		packet.PDUType == gosnmp.GetRequest {
+		return true
+	}
+	return usm.UserName == x.SecurityParameters.(*gosnmp.UsmSecurityParameters).UserName &&
+		packet.MsgFlags&gosnmp.AuthPriv == x.MsgFlags&gosnmp.AuthPriv
+}
+
+// report returns the report of the receiver's engine ID sent in answer to an
+// SNMPv3 message for another engine.
+func (r *TrapReceiver) report(packet *gosnmp.SnmpPacket) []byte {
+	resp := &gosnmp.SnmpPacket{
+		Version:         gosnmp.Version3,
+		MsgID:           packet.MsgID,
+		MsgFlags:        gosnmp.NoAuthNoPriv,
+		SecurityModel:   gosnmp.UserSecurityModel,
+		ContextEngineID: r.EngineID,
+		ContextName:     packet.ContextName,
+		RequestID:       packet.RequestID,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			AuthoritativeEngineID:    r.EngineID,
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  r.engineTime(),
+		},
+		PDUType: gosnmp.Report,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: usmStatsUnknownEngineIDs, Type: gosnmp.Counter32, Value: uint32(1)},
+		},
+	}
+	data, err := resp.MarshalMsg()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// acknowledge returns the response to an inform, which echoes its variables.
+func (r *TrapReceiver) acknowledge(x *gosnmp.GoSNMP, packet *gosnmp.SnmpPacket) []byte {
+	resp := &gosnmp.SnmpPacket{
+		Version:         packet.Version,
+		Community:       packet.Community,
+		MsgID:           packet.MsgID,
+		MsgFlags:        packet.MsgFlags &^ gosnmp.Reportable,
+		SecurityModel:   packet.SecurityModel,
+		ContextEngineID: packet.ContextEngineID,
+		ContextName:     packet.ContextName,
+		PDUType:         gosnmp.GetResponse,
+		RequestID:       packet.RequestID,
+		Variables:       packet.Variables,
+	}
+	if packet.Version == gosnmp.Version3 {
+		usm := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		respUsm := x.SecurityParameters.(*gosnmp.UsmSecurityParameters).Copy().(*gosnmp.UsmSecurityParameters)
+		respUsm.AuthoritativeEngineBoots = 1
+		respUsm.AuthoritativeEngineTime = r.engineTime()
+		respUsm.SecretKey = usm.SecretKey
+		respUsm.PrivacyKey = usm.PrivacyKey
+		respUsm.PrivacyParameters = make([]byte, 8)
+		rand.Read(respUsm.PrivacyParameters)
+		resp.SecurityParameters = respUsm
+	}
+	data, err := resp.MarshalMsg()
+	if err != nil {
+		slog.Error("Failed to acknowledge an SNMP inform", "error", err)
+		return nil
+	}
+	return data
+}
+
+// engineTime returns the number of seconds since the receiver started.
+func (r *TrapReceiver) engineTime() uint32 {
+	return uint32(time.Since(r.start).Seconds())
+}
+
+// String describes the notification for logging.
+func (n Notification) String() string {
+	kind := "trap"
+	if n.Inform {
+		kind = "inform"
+	}
+	return fmt.Sprintf("%v %v from %v", kind, n.TrapOID, n.Source)
+}
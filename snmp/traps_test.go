@@ -0,0 +1,125 @@
+package snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/soniah/gosnmp"
+)
+
+var linkDown = []gosnmp.SnmpPDU{
+	{Name: snmpTrapOid, Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.3"},
+	{Name: ".1.3.6.1.2.1.2.2.1.1.524", Type: gosnmp.Integer, Value: 524},
+	{Name: ".1.3.6.1.2.1.2.2.1.8.524", Type: gosnmp.Integer, Value: 2},
+}
+
+// startReceiver starts a TrapReceiver on the loopback interface for creds,
+// returning it along with a channel of the notifications it receives.
+func startReceiver(t *testing.T, creds Credentials) (*TrapReceiver, chan Notification) {
+	notifications := make(chan Notification, 10)
+	r, err := NewTrapReceiver("127.0.0.1:0",
+		func() (Credentials, error) { return creds, nil },
+		func(n Notification) { notifications <- n })
+	if err != nil {
+		t.Fatalf("NewTrapReceiver() error = %v", err)
+	}
+	return r, notifications
+}
+
+// sender returns a gosnmp client that sends notifications to r with creds.
+func sender(t *testing.T, r *TrapReceiver, creds Credentials) *gosnmp.GoSNMP {
+	x := &gosnmp.GoSNMP{
+		Version: gosnmp.Version2c,
+		Target:  "127.0.0.1",
+		Port:    uint16(r.Addr().Port),
+		Timeout: 500 * time.Millisecond,
+		MaxOids: gosnmp.MaxOids,
+	}
+	creds.apply(x)
+	if err := x.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	return x
+}
+
+func Test_TrapReceiver(t *testing.T) {
+	v2c := Credentials{Community: "public"}
+	v3 := Credentials{
+		Username:       "disco",
+		AuthProtocol:   "SHA256",
+		AuthPassphrase: "authpassphrase",
+		PrivProtocol:   "AES",
+		PrivPassphrase: "privpassphrase",
+	}
+	tests := []struct {
+		name   string
+		creds  Credentials
+		sent   Credentials
+		inform bool
+		want   bool
+	}{
+		{name: "v2c trap", creds: v2c, sent: v2c, want: true},
+		{name: "v2c inform", creds: v2c, sent: v2c, inform: true, want: true},
+		{name: "v2c wrong community", creds: v2c, sent: Credentials{Community: "private"}},
+		{name: "v3 trap", creds: v3, sent: v3, want: true},
+		{name: "v3 inform", creds: v3, sent: v3, inform: true, want: true},
+		{name: "v3 unauthenticated", creds: v3, sent: Credentials{Username: "disco"}},
+		{name: "v3 wrong passphrase", creds: v3, sent: Credentials{
+			Username:       "disco",
+			AuthProtocol:   "SHA256",
+			AuthPassphrase: "wrongpassphrase",
+			PrivProtocol:   "AES",
+			PrivPassphrase: "privpassphrase",
+		}},
+		{name: "v3 to v2c", creds: v2c, sent: v3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, notifications := startReceiver(t, tt.creds)
+			defer r.Close()
+			rejected := testutil.ToFloat64(notificationsRejected)
+
+			x := sender(t, r, tt.sent)
+			defer x.Conn.Close()
+			_, err := x.SendTrap(gosnmp.SnmpTrap{Variables: linkDown, IsInform: tt.inform})
+			if tt.inform && tt.want && err != nil {
+				t.Errorf("SendTrap() of an inform error = %v", err)
+			}
+
+			select {
+			case n := <-notifications:
+				if !tt.want {
+					t.Fatalf("Got unexpected notification %v", n)
+				}
+				if n.TrapOID != ".1.3.6.1.6.3.1.1.5.3" || n.Inform != tt.inform || !n.Source.IsLoopback() {
+					t.Errorf("Got notification %v, want a linkDown %v", n, map[bool]string{false: "trap", true: "inform"}[tt.inform])
+				}
+			case <-time.After(time.Second):
+				if tt.want {
+					t.Fatalf("Timed out waiting for the notification")
+				}
+				if testutil.ToFloat64(notificationsRejected) == rejected {
+					t.Errorf("Expected the notification to be counted as rejected")
+				}
+			}
+		})
+	}
+}
+
+func Test_TrapReceiverIgnoresRequests(t *testing.T) {
+	creds := Credentials{Community: "public"}
+	r, notifications := startReceiver(t, creds)
+	defer r.Close()
+
+	x := sender(t, r, creds)
+	defer x.Conn.Close()
+	x.Retries = 0
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	if err == nil {
+		t.Errorf("Expected a Get of the receiver to time out")
+	}
+	if len(notifications) != 0 {
+		t.Errorf("Expected no notification for a Get")
+	}
+}